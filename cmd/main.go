@@ -3,11 +3,14 @@ package main
 import (
 	"log"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/Anabol1ks/ozon_tz/graph"
-	"github.com/Anabol1ks/ozon_tz/graph/model"
-	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"github.com/Anabol1ks/ozon_tz/graph/loaders"
+	"github.com/Anabol1ks/ozon_tz/pkg/auth"
+	"github.com/Anabol1ks/ozon_tz/pkg/pubsub"
 	"github.com/Anabol1ks/ozon_tz/pkg/storage"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -30,25 +33,80 @@ func main() {
 	if err := storage.InitStorage(storageType); err != nil {
 		log.Fatal("Ошибка инициализации хранилища:", err)
 	}
+	defer storage.Close()
 
-	if storageType == "postgres" {
-		if err := storage.DB.AutoMigrate(&models.User{}, &models.Post{}, &models.Comment{}); err != nil {
-			log.Fatal("Ошибка миграции:", err)
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(storage.DB, os.Args[2:])
+		return
+	}
+	if storage.DB != nil {
+		checkMigrations(storage.DB)
+	}
+
+	jwtSecret := os.Getenv("JWT_SECRET")
+	if jwtSecret == "" {
+		log.Fatal("Переменная JWT_SECRET не задана")
+	}
+	tokenTTL := 24 * time.Hour
+	if ttl := os.Getenv("JWT_TTL"); ttl != "" {
+		parsed, err := time.ParseDuration(ttl)
+		if err != nil {
+			log.Fatal("Некорректное значение JWT_TTL:", err)
 		}
+		tokenTTL = parsed
 	}
+	authManager := auth.NewManager(jwtSecret, tokenTTL)
+
+	pubsubType := os.Getenv("PUBSUB_TYPE")
+	if pubsubType == "" {
+		pubsubType = "memory"
+	}
+	pubsubOpts := pubsub.Options{Overflow: pubsub.OverflowPolicy(os.Getenv("PUBSUB_OVERFLOW"))}
+	if size := os.Getenv("PUBSUB_QUEUE_SIZE"); size != "" {
+		parsed, err := strconv.Atoi(size)
+		if err != nil {
+			log.Fatal("Некорректное значение PUBSUB_QUEUE_SIZE:", err)
+		}
+		pubsubOpts.QueueSize = parsed
+	}
+	if timeout := os.Getenv("PUBSUB_BLOCK_TIMEOUT"); timeout != "" {
+		parsed, err := time.ParseDuration(timeout)
+		if err != nil {
+			log.Fatal("Некорректное значение PUBSUB_BLOCK_TIMEOUT:", err)
+		}
+		pubsubOpts.BlockTimeout = parsed
+	}
+	events, err := pubsub.New(pubsubType, storage.DB, pubsubOpts)
+	if err != nil {
+		log.Fatal("Ошибка инициализации pub/sub:", err)
+	}
+	defer events.Close()
 
 	resolver := &graph.Resolver{
-		DB:               storage.DB,
-		Store:            storage.Store,
-		CommentObservers: make(map[string][]chan *model.Comment),
+		DB:     storage.DB,
+		Store:  storage.Store,
+		Auth:   authManager,
+		PubSub: events,
+	}
+	if depth := os.Getenv("MAX_REPLY_DEPTH"); depth != "" {
+		parsed, err := strconv.Atoi(depth)
+		if err != nil || parsed < 0 {
+			log.Fatal("Некорректное значение MAX_REPLY_DEPTH:", depth)
+		}
+		resolver.MaxReplyDepth = parsed
 	}
 
 	r := gin.Default()
+	r.Use(auth.Middleware(authManager, storage.Store))
 
 	srv := handler.NewDefaultServer(graph.NewExecutableSchema(graph.Config{Resolvers: resolver}))
+	srv.SetErrorPresenter(graph.ErrorPresenter)
+	srv.Use(graph.SubscriptionErrors{})
+
+	queryHandler := loaders.Middleware(storage.Store, srv)
 
-	r.POST("/query", gin.WrapH(srv))
-	r.GET("/query", gin.WrapH(srv))
+	r.POST("/query", gin.WrapH(queryHandler))
+	r.GET("/query", gin.WrapH(queryHandler))
 
 	if err := r.Run(":8080"); err != nil {
 		log.Fatal("Ошибка запуска сервера:", err)
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Anabol1ks/ozon_tz/pkg/migrate"
+	"gorm.io/gorm"
+)
+
+const migrateUsage = "использование: main migrate up|down|status"
+
+// runMigrate выполняет подкоманду migrate и завершает процесс.
+// Для хранилища без базы (memory) подкоманда ничего не делает, чтобы
+// образ с `migrate up` перед стартом запускался при любом STORAGE_TYPE.
+func runMigrate(db *gorm.DB, args []string) {
+	if len(args) != 1 {
+		log.Fatal(migrateUsage)
+	}
+	if db == nil {
+		log.Println("Хранилище без базы данных, миграции не нужны")
+		return
+	}
+
+	m, err := migrate.New(db)
+	if err != nil {
+		log.Fatal("Ошибка загрузки миграций:", err)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up()
+		for _, migration := range applied {
+			log.Printf("Применена миграция %04d_%s", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatal("Ошибка миграции:", err)
+		}
+		if len(applied) == 0 {
+			log.Println("Новых миграций нет")
+		}
+	case "down":
+		migration, err := m.Down()
+		if err != nil {
+			log.Fatal("Ошибка отката миграции:", err)
+		}
+		if migration == nil {
+			log.Println("Нет применённых миграций")
+			return
+		}
+		log.Printf("Откачена миграция %04d_%s", migration.Version, migration.Name)
+	case "status":
+		statuses, err := m.Status()
+		if err != nil {
+			log.Fatal("Ошибка получения статуса миграций:", err)
+		}
+		for _, status := range statuses {
+			applied := "не применена"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(os.Stdout, "%04d_%s\t%s\n", status.Version, status.Name, applied)
+		}
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
+
+// checkMigrations не даёт запустить сервер на схеме, отставшей от кода.
+func checkMigrations(db *gorm.DB) {
+	m, err := migrate.New(db)
+	if err != nil {
+		log.Fatal("Ошибка загрузки миграций:", err)
+	}
+	pending, err := m.Pending()
+	if err != nil {
+		log.Fatal("Ошибка проверки миграций:", err)
+	}
+	if len(pending) > 0 {
+		log.Fatalf("Есть неприменённые миграции (%d), выполните: main migrate up", len(pending))
+	}
+}
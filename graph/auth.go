@@ -0,0 +1,58 @@
+package graph
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Anabol1ks/ozon_tz/graph/model"
+	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"github.com/Anabol1ks/ozon_tz/pkg/auth"
+	"github.com/Anabol1ks/ozon_tz/pkg/storage"
+)
+
+const (
+	minPasswordLength = 8
+	// maxPasswordBytes — bcrypt не принимает пароли длиннее 72 байт
+	maxPasswordBytes = 72
+)
+
+var (
+	errUnauthenticated    = errors.New("требуется авторизация")
+	errInvalidCredentials = errors.New("неверное имя пользователя или пароль")
+	errAdminOnly          = storage.NewError(storage.ErrForbidden, "требуются права администратора")
+)
+
+// currentUser возвращает пользователя, которого auth.Middleware положил в контекст.
+func currentUser(ctx context.Context) (*models.User, error) {
+	user, ok := auth.UserFromContext(ctx)
+	if !ok {
+		return nil, errUnauthenticated
+	}
+	return user, nil
+}
+
+func currentAdmin(ctx context.Context) (*models.User, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsAdmin {
+		return nil, errAdminOnly
+	}
+	return user, nil
+}
+
+// canSeeRevisions — историю правок видят только автор и администраторы:
+// в ней остаётся текст удалённых комментариев.
+func canSeeRevisions(ctx context.Context, authorID uint) bool {
+	user, ok := auth.UserFromContext(ctx)
+	return ok && (user.ID == authorID || user.IsAdmin)
+}
+
+func (r *Resolver) authPayload(user *models.User) (*model.AuthPayload, error) {
+	token, err := r.Auth.IssueToken(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.AuthPayload{Token: token, User: dbUserToGraphQL(user)}, nil
+}
@@ -0,0 +1,106 @@
+package graph
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Anabol1ks/ozon_tz/graph/model"
+	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"github.com/Anabol1ks/ozon_tz/pkg/storage"
+	"gorm.io/gorm"
+)
+
+func dbUserToGraphQL(dbUser *models.User) *model.User {
+	return &model.User{
+		ID:        strconv.FormatUint(uint64(dbUser.ID), 10),
+		Username:  dbUser.Username,
+		CreatedAt: dbUser.CreatedAt.String(),
+	}
+}
+
+func dbPostToGraphQL(dbPost *models.Post) *model.Post {
+	return &model.Post{
+		ID:               strconv.FormatUint(uint64(dbPost.ID), 10),
+		Title:            dbPost.Title,
+		Content:          dbPost.Content,
+		AuthorID:         dbPost.AuthorID,
+		DisableComments:  dbPost.DisableComments,
+		MaxReplyDepth:    maxReplyDepth(dbPost.MaxReplyDepth),
+		CommentCount:     int32(dbPost.CommentCount),
+		ParticipantCount: int32(dbPost.ParticipantCount),
+		LastCommentAt:    timeString(dbPost.LastCommentAt),
+		CreatedAt:        dbPost.CreatedAt.String(),
+		DeletedAt:        deletedAt(dbPost.DeletedAt),
+	}
+}
+
+func dbCommentToGraphQL(dbComment *models.Comment) *model.Comment {
+	comment := &model.Comment{
+		ID:        strconv.FormatUint(uint64(dbComment.ID), 10),
+		PostID:    dbComment.PostID,
+		AuthorID:  dbComment.AuthorID,
+		ParentID:  dbComment.ParentID,
+		ReplyToID: dbComment.ReplyToID,
+		Path:      dbComment.Path,
+		Depth:     int32(dbComment.Depth),
+		Content:   dbComment.Content,
+		Deleted:   dbComment.Deleted,
+		CreatedAt: dbComment.CreatedAt.String(),
+		DeletedAt: deletedAt(dbComment.DeletedAt),
+	}
+	comment.EditedAt = timeString(dbComment.EditedAt)
+	return comment
+}
+
+func dbThreadToGraphQL(thread []*storage.ThreadComment) []*model.ThreadComment {
+	result := make([]*model.ThreadComment, len(thread))
+	for i, item := range thread {
+		path := make([]string, len(item.Path))
+		for j, id := range item.Path {
+			path[j] = strconv.FormatUint(uint64(id), 10)
+		}
+		result[i] = &model.ThreadComment{
+			Comment: dbCommentToGraphQL(item.Comment),
+			Depth:   int32(item.Depth),
+			Path:    path,
+		}
+	}
+	return result
+}
+
+func maxReplyDepth(depth *int) *int32 {
+	if depth == nil {
+		return nil
+	}
+	value := int32(*depth)
+	return &value
+}
+
+func deletedAt(at gorm.DeletedAt) *string {
+	if !at.Valid {
+		return nil
+	}
+	s := at.Time.String()
+	return &s
+}
+
+func timeString(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := t.String()
+	return &s
+}
+
+func dbRevisionToGraphQL(dbRevision *models.Revision) *model.Revision {
+	revision := &model.Revision{
+		ID:        strconv.FormatUint(uint64(dbRevision.ID), 10),
+		AuthorID:  dbRevision.AuthorID,
+		Content:   dbRevision.Content,
+		CreatedAt: dbRevision.CreatedAt.String(),
+	}
+	if dbRevision.EntityType == models.RevisionPost {
+		revision.Title = &dbRevision.Title
+	}
+	return revision
+}
@@ -0,0 +1,48 @@
+package graph
+
+import (
+	"context"
+	"errors"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/Anabol1ks/ozon_tz/pkg/storage"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// ErrorPresenter добавляет в extensions.code машиночитаемый код ошибки,
+// чтобы клиент мог ветвиться по нему, а не по тексту сообщения.
+func ErrorPresenter(ctx context.Context, err error) *gqlerror.Error {
+	gqlErr := graphql.DefaultErrorPresenter(ctx, err)
+
+	if code := errorCode(err); code != "" {
+		if gqlErr.Extensions == nil {
+			gqlErr.Extensions = make(map[string]interface{})
+		}
+		gqlErr.Extensions["code"] = code
+	}
+
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		gqlErr.Extensions["field"] = validationErr.Field
+	}
+	return gqlErr
+}
+
+func errorCode(err error) string {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return "NOT_FOUND"
+	case errors.Is(err, storage.ErrForbidden):
+		return "FORBIDDEN"
+	case errors.Is(err, storage.ErrValidation):
+		return "BAD_USER_INPUT"
+	case errors.Is(err, storage.ErrConflict):
+		return "CONFLICT"
+	case errors.Is(err, errUnauthenticated), errors.Is(err, errInvalidCredentials):
+		return "UNAUTHENTICATED"
+	case errors.Is(err, errSubscriptionOverflow):
+		return "SLOW_CONSUMER"
+	default:
+		return ""
+	}
+}
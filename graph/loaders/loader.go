@@ -0,0 +1,134 @@
+package loaders
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// fetchFunc загружает значения для пачки ключей. Результаты и ошибки
+// должны идти в том же порядке, что и ключи.
+type fetchFunc[K comparable, V any] func(keys []K) ([]V, []error)
+
+type result[V any] struct {
+	value V
+	err   error
+	done  chan struct{}
+}
+
+type batch[K comparable, V any] struct {
+	keys    []K
+	results []*result[V]
+}
+
+// Loader собирает одиночные запросы Load в течение короткого окна wait
+// и выполняет их одним вызовом fetch. Результаты кэшируются на время
+// жизни загрузчика, поэтому он создаётся заново на каждый запрос.
+type Loader[K comparable, V any] struct {
+	fetch    fetchFunc[K, V]
+	wait     time.Duration
+	maxBatch int
+
+	mu    sync.Mutex
+	cache map[K]*result[V]
+	batch *batch[K, V]
+}
+
+func newLoader[K comparable, V any](fetch fetchFunc[K, V], wait time.Duration, maxBatch int) *Loader[K, V] {
+	return &Loader[K, V]{
+		fetch:    fetch,
+		wait:     wait,
+		maxBatch: maxBatch,
+		cache:    make(map[K]*result[V]),
+	}
+}
+
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	l.mu.Lock()
+	res := l.enqueue(key)
+	l.mu.Unlock()
+
+	return res.wait(ctx)
+}
+
+// LoadMany ставит все ключи в одну пачку, а не ждёт окно на каждый ключ по очереди.
+// Ошибки возвращаются по ключам, в том же порядке.
+func (l *Loader[K, V]) LoadMany(ctx context.Context, keys []K) ([]V, []error) {
+	l.mu.Lock()
+	results := make([]*result[V], len(keys))
+	for i, key := range keys {
+		results[i] = l.enqueue(key)
+	}
+	l.mu.Unlock()
+
+	values := make([]V, len(keys))
+	errs := make([]error, len(keys))
+	for i, res := range results {
+		values[i], errs[i] = res.wait(ctx)
+	}
+	return values, errs
+}
+
+// enqueue возвращает результат ключа из кэша или добавляет ключ в текущую пачку.
+// Вызывается под l.mu.
+func (l *Loader[K, V]) enqueue(key K) *result[V] {
+	if res, ok := l.cache[key]; ok {
+		return res
+	}
+	res := &result[V]{done: make(chan struct{})}
+	l.cache[key] = res
+
+	if l.batch == nil {
+		l.batch = &batch[K, V]{}
+	}
+	l.batch.keys = append(l.batch.keys, key)
+	l.batch.results = append(l.batch.results, res)
+
+	// Окно ожидания открывает первый ключ пачки, если она не заполнена им же
+	switch b := l.batch; {
+	case len(b.keys) >= l.maxBatch:
+		l.batch = nil
+		go l.run(b)
+	case len(b.keys) == 1:
+		go l.dispatchAfter(b)
+	}
+	return res
+}
+
+func (r *result[V]) wait(ctx context.Context) (V, error) {
+	select {
+	case <-r.done:
+		return r.value, r.err
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	}
+}
+
+func (l *Loader[K, V]) dispatchAfter(b *batch[K, V]) {
+	time.Sleep(l.wait)
+
+	l.mu.Lock()
+	if l.batch != b {
+		// Пачка уже отправлена по достижении maxBatch
+		l.mu.Unlock()
+		return
+	}
+	l.batch = nil
+	l.mu.Unlock()
+
+	l.run(b)
+}
+
+func (l *Loader[K, V]) run(b *batch[K, V]) {
+	values, errs := l.fetch(b.keys)
+	for i, res := range b.results {
+		if i < len(values) {
+			res.value = values[i]
+		}
+		if i < len(errs) {
+			res.err = errs[i]
+		}
+		close(res.done)
+	}
+}
@@ -0,0 +1,223 @@
+package loaders
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"github.com/Anabol1ks/ozon_tz/pkg/storage"
+)
+
+const (
+	batchWait = 2 * time.Millisecond
+	maxBatch  = 100
+)
+
+type ctxKey struct{}
+
+// ChildrenKey — ключ загрузчика ответов: один и тот же комментарий
+// может запрашиваться с разной сортировкой и числом ответов в рамках одного запроса.
+type ChildrenKey struct {
+	ParentID uint
+	Order    storage.SortOrder
+	Limit    int
+}
+
+// RevisionKey — ключ загрузчика ревизий: у постов и комментариев свои
+// последовательности id, поэтому тип сущности входит в ключ.
+type RevisionKey struct {
+	EntityType string
+	EntityID   uint
+}
+
+type Loaders struct {
+	UserByID           *Loader[uint, *models.User]
+	PostByID           *Loader[uint, *models.Post]
+	CommentByID        *Loader[uint, *models.Comment]
+	ChildrenByParentID *Loader[ChildrenKey, []*models.Comment]
+	RevisionsByEntity  *Loader[RevisionKey, []*models.Revision]
+	ReplyCountsByID    *Loader[uint, storage.ReplyCounts]
+}
+
+// NewLoaders создаёт загрузчики, запросы которых выполняются в контексте ctx.
+func NewLoaders(ctx context.Context, store storage.Storage) *Loaders {
+	return newLoaders(ctx, store, batchWait, maxBatch)
+}
+
+// NewUnbatched создаёт загрузчики без окна ожидания: каждый ключ загружается
+// сразу. Подходит там, где некому собирать пачку, например для подписок.
+func NewUnbatched(ctx context.Context, store storage.Storage) *Loaders {
+	return newLoaders(ctx, store, 0, 1)
+}
+
+func newLoaders(ctx context.Context, store storage.Storage, wait time.Duration, maxBatch int) *Loaders {
+	return &Loaders{
+		UserByID:           newLoader(usersFetcher(ctx, store), wait, maxBatch),
+		PostByID:           newLoader(postsFetcher(ctx, store), wait, maxBatch),
+		CommentByID:        newLoader(commentsFetcher(ctx, store), wait, maxBatch),
+		ChildrenByParentID: newLoader(childrenFetcher(ctx, store), wait, maxBatch),
+		RevisionsByEntity:  newLoader(revisionsFetcher(ctx, store), wait, maxBatch),
+		ReplyCountsByID:    newLoader(replyCountsFetcher(ctx, store), wait, maxBatch),
+	}
+}
+
+// Middleware кладёт в контекст свежий набор загрузчиков на каждый HTTP-запрос.
+// WebSocket-подписки живут дольше одного запроса, поэтому для них загрузчики
+// не создаются, чтобы не отдавать клиенту устаревший кэш.
+func Middleware(store storage.Storage, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := context.WithValue(r.Context(), ctxKey{}, NewLoaders(r.Context(), store))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// For возвращает загрузчики из контекста или nil, если их там нет.
+func For(ctx context.Context) *Loaders {
+	l, _ := ctx.Value(ctxKey{}).(*Loaders)
+	return l
+}
+
+func usersFetcher(ctx context.Context, store storage.Storage) fetchFunc[uint, *models.User] {
+	return func(ids []uint) ([]*models.User, []error) {
+		users, err := store.GetUsersByIDs(ctx, ids)
+		if err != nil {
+			return nil, fill(len(ids), err)
+		}
+
+		byID := make(map[uint]*models.User, len(users))
+		for _, user := range users {
+			byID[user.ID] = user
+		}
+		return collect(ids, byID, storage.NotFound("user"))
+	}
+}
+
+func postsFetcher(ctx context.Context, store storage.Storage) fetchFunc[uint, *models.Post] {
+	return func(ids []uint) ([]*models.Post, []error) {
+		posts, err := store.GetPostsByIDs(ctx, ids)
+		if err != nil {
+			return nil, fill(len(ids), err)
+		}
+
+		byID := make(map[uint]*models.Post, len(posts))
+		for _, post := range posts {
+			byID[post.ID] = post
+		}
+		return collect(ids, byID, storage.NotFound("post"))
+	}
+}
+
+func commentsFetcher(ctx context.Context, store storage.Storage) fetchFunc[uint, *models.Comment] {
+	return func(ids []uint) ([]*models.Comment, []error) {
+		comments, err := store.GetCommentsByIDs(ctx, ids)
+		if err != nil {
+			return nil, fill(len(ids), err)
+		}
+
+		byID := make(map[uint]*models.Comment, len(comments))
+		for _, comment := range comments {
+			byID[comment.ID] = comment
+		}
+		return collect(ids, byID, storage.NotFound("comment"))
+	}
+}
+
+func childrenFetcher(ctx context.Context, store storage.Storage) fetchFunc[ChildrenKey, []*models.Comment] {
+	return func(keys []ChildrenKey) ([][]*models.Comment, []error) {
+		type group struct {
+			order storage.SortOrder
+			limit int
+		}
+		byGroup := make(map[group][]uint)
+		for _, key := range keys {
+			g := group{order: key.Order, limit: key.Limit}
+			byGroup[g] = append(byGroup[g], key.ParentID)
+		}
+
+		children := make(map[ChildrenKey][]*models.Comment, len(keys))
+		for g, parentIDs := range byGroup {
+			byParent, err := store.GetChildrenByParentIDs(ctx, parentIDs, g.order, g.limit)
+			if err != nil {
+				return nil, fill(len(keys), err)
+			}
+			for id, list := range byParent {
+				children[ChildrenKey{ParentID: id, Order: g.order, Limit: g.limit}] = list
+			}
+		}
+
+		result := make([][]*models.Comment, len(keys))
+		for i, key := range keys {
+			result[i] = children[key]
+		}
+		return result, nil
+	}
+}
+
+func revisionsFetcher(ctx context.Context, store storage.Storage) fetchFunc[RevisionKey, []*models.Revision] {
+	return func(keys []RevisionKey) ([][]*models.Revision, []error) {
+		byType := make(map[string][]uint)
+		for _, key := range keys {
+			byType[key.EntityType] = append(byType[key.EntityType], key.EntityID)
+		}
+
+		revisions := make(map[RevisionKey][]*models.Revision, len(keys))
+		for entityType, ids := range byType {
+			byEntity, err := store.GetRevisionsByEntityIDs(ctx, entityType, ids)
+			if err != nil {
+				return nil, fill(len(keys), err)
+			}
+			for id, list := range byEntity {
+				revisions[RevisionKey{EntityType: entityType, EntityID: id}] = list
+			}
+		}
+
+		result := make([][]*models.Revision, len(keys))
+		for i, key := range keys {
+			result[i] = revisions[key]
+		}
+		return result, nil
+	}
+}
+
+func replyCountsFetcher(ctx context.Context, store storage.Storage) fetchFunc[uint, storage.ReplyCounts] {
+	return func(ids []uint) ([]storage.ReplyCounts, []error) {
+		counts, err := store.GetReplyCounts(ctx, ids)
+		if err != nil {
+			return nil, fill(len(ids), err)
+		}
+
+		result := make([]storage.ReplyCounts, len(ids))
+		for i, id := range ids {
+			result[i] = counts[id]
+		}
+		return result, nil
+	}
+}
+
+func collect[V any](ids []uint, byID map[uint]V, notFound error) ([]V, []error) {
+	values := make([]V, len(ids))
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		value, ok := byID[id]
+		if !ok {
+			errs[i] = notFound
+			continue
+		}
+		values[i] = value
+	}
+	return values, errs
+}
+
+func fill(n int, err error) []error {
+	errs := make([]error, n)
+	for i := range errs {
+		errs[i] = err
+	}
+	return errs
+}
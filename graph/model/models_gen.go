@@ -2,37 +2,182 @@
 
 package model
 
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+type AuthPayload struct {
+	Token string `json:"token"`
+	User  *User  `json:"user"`
+}
+
 type Comment struct {
-	ID        string     `json:"id"`
-	Post      *Post      `json:"post"`
-	Author    *User      `json:"author"`
-	Parent    *Comment   `json:"parent,omitempty"`
-	Content   string     `json:"content"`
-	CreatedAt string     `json:"createdAt"`
-	Children  []*Comment `json:"children"`
+	ID     string   `json:"id"`
+	Post   *Post    `json:"post"`
+	Author *User    `json:"author"`
+	Parent *Comment `json:"parent,omitempty"`
+	// Комментарий, на который отвечали, если ответ оказался бы глубже допустимого
+	// и был прикреплён к его предку (parent). У остальных комментариев null.
+	ReplyTo   *Comment `json:"replyTo,omitempty"`
+	Content   string   `json:"content"`
+	CreatedAt string   `json:"createdAt"`
+	EditedAt  *string  `json:"editedAt,omitempty"`
+	Deleted   bool     `json:"deleted"`
+	DeletedAt *string  `json:"deletedAt,omitempty"`
+	// Уровень вложенности: 0 у комментариев верхнего уровня.
+	Depth int32 `json:"depth"`
+	// Предки комментария от корня ветки к родителю.
+	Ancestors []*Comment `json:"ancestors"`
+	// Все ответы на комментарий на любой глубине, от старых к новым.
+	Descendants []*Comment `json:"descendants"`
+	// Число прямых ответов и всех ответов на любой глубине.
+	ReplyCount      int32 `json:"replyCount"`
+	DescendantCount int32 `json:"descendantCount"`
+	// Первые first ответов на комментарий (по умолчанию 20, не больше 100); с after —
+	// ответы после ответа с id after, для «показать ещё». Полный список постранично
+	// отдаёт replies.
+	Children []*Comment         `json:"children"`
+	Replies  *CommentConnection `json:"replies"`
+	// Предыдущие версии комментария, от новых к старым. Видны только автору и
+	// администраторам: в истории остаётся текст удалённого комментария.
+	Revisions []*Revision `json:"revisions"`
+	AuthorID  uint        `json:"-"`
+	ParentID  *uint       `json:"-"`
+	Path      string      `json:"-"`
+	PostID    uint        `json:"-"`
+	ReplyToID *uint       `json:"-"`
+}
+
+type CommentConnection struct {
+	Edges    []*CommentEdge `json:"edges"`
+	PageInfo *PageInfo      `json:"pageInfo"`
+}
+
+type CommentEdge struct {
+	Cursor string   `json:"cursor"`
+	Node   *Comment `json:"node"`
 }
 
 type Mutation struct {
 }
 
+type PageInfo struct {
+	HasNextPage bool    `json:"hasNextPage"`
+	EndCursor   *string `json:"endCursor,omitempty"`
+}
+
 type Post struct {
-	ID              string     `json:"id"`
-	Title           string     `json:"title"`
-	Content         string     `json:"content"`
-	Author          *User      `json:"author"`
-	DisableComments bool       `json:"disableComments"`
-	CreatedAt       string     `json:"createdAt"`
-	Comments        []*Comment `json:"comments"`
+	ID              string `json:"id"`
+	Title           string `json:"title"`
+	Content         string `json:"content"`
+	Author          *User  `json:"author"`
+	DisableComments bool   `json:"disableComments"`
+	// Допустимая глубина ответов в этом посте; null — действует ограничение сервиса (MAX_REPLY_DEPTH).
+	MaxReplyDepth *int32 `json:"maxReplyDepth,omitempty"`
+	// Статистика обсуждения: видимые комментарии (без надгробий «[deleted]»),
+	// число их разных авторов и время последнего; null — комментариев нет.
+	CommentCount     int32      `json:"commentCount"`
+	ParticipantCount int32      `json:"participantCount"`
+	LastCommentAt    *string    `json:"lastCommentAt,omitempty"`
+	CreatedAt        string     `json:"createdAt"`
+	DeletedAt        *string    `json:"deletedAt,omitempty"`
+	Comments         []*Comment `json:"comments"`
+	// Вся ветка обсуждения одним запросом: комментарии в порядке обхода в глубину,
+	// ответы сразу после родителя. rootLimit ограничивает число корневых комментариев
+	// (от старых к новым), maxDepth — число уровней; без аргументов ветка отдаётся целиком.
+	Thread []*ThreadComment `json:"thread"`
+	// Предыдущие версии поста, от новых к старым. Видны только автору и администраторам,
+	// остальным приходит пустой список.
+	Revisions []*Revision `json:"revisions"`
+	AuthorID  uint        `json:"-"`
+}
+
+type PostConnection struct {
+	Edges    []*PostEdge `json:"edges"`
+	PageInfo *PageInfo   `json:"pageInfo"`
+}
+
+type PostEdge struct {
+	Cursor string `json:"cursor"`
+	Node   *Post  `json:"node"`
 }
 
 type Query struct {
 }
 
+// Версия поста или комментария до изменения. title заполнен только у постов.
+type Revision struct {
+	ID        string  `json:"id"`
+	Author    *User   `json:"author"`
+	Title     *string `json:"title,omitempty"`
+	Content   string  `json:"content"`
+	CreatedAt string  `json:"createdAt"`
+	AuthorID  uint    `json:"-"`
+}
+
 type Subscription struct {
 }
 
+// Комментарий в плоском представлении ветки.
+type ThreadComment struct {
+	Comment *Comment `json:"comment"`
+	// 0 у корневых комментариев.
+	Depth int32 `json:"depth"`
+	// id предков от корня, последним идёт сам комментарий.
+	Path []string `json:"path"`
+}
+
 type User struct {
 	ID        string `json:"id"`
 	Username  string `json:"username"`
 	CreatedAt string `json:"createdAt"`
 }
+
+type SortOrder string
+
+const (
+	SortOrderNewest           SortOrder = "NEWEST"
+	SortOrderOldest           SortOrder = "OLDEST"
+	SortOrderMostReplies      SortOrder = "MOST_REPLIES"
+	SortOrderMostParticipants SortOrder = "MOST_PARTICIPANTS"
+	SortOrderRecentlyActive   SortOrder = "RECENTLY_ACTIVE"
+)
+
+var AllSortOrder = []SortOrder{
+	SortOrderNewest,
+	SortOrderOldest,
+	SortOrderMostReplies,
+	SortOrderMostParticipants,
+	SortOrderRecentlyActive,
+}
+
+func (e SortOrder) IsValid() bool {
+	switch e {
+	case SortOrderNewest, SortOrderOldest, SortOrderMostReplies, SortOrderMostParticipants, SortOrderRecentlyActive:
+		return true
+	}
+	return false
+}
+
+func (e SortOrder) String() string {
+	return string(e)
+}
+
+func (e *SortOrder) UnmarshalGQL(v any) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = SortOrder(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid SortOrder", str)
+	}
+	return nil
+}
+
+func (e SortOrder) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
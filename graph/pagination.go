@@ -0,0 +1,88 @@
+package graph
+
+import (
+	"github.com/Anabol1ks/ozon_tz/graph/model"
+	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"github.com/Anabol1ks/ozon_tz/pkg/storage"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+var sortOrders = map[model.SortOrder]storage.SortOrder{
+	model.SortOrderNewest:           storage.SortNewest,
+	model.SortOrderOldest:           storage.SortOldest,
+	model.SortOrderMostReplies:      storage.SortMostReplies,
+	model.SortOrderMostParticipants: storage.SortMostParticipants,
+	model.SortOrderRecentlyActive:   storage.SortRecentlyActive,
+}
+
+// sortOrder переводит аргумент orderBy в порядок хранилища. Значение по умолчанию
+// задаётся в схеме, но клиент может явно передать null.
+func sortOrder(orderBy *model.SortOrder, fallback storage.SortOrder) storage.SortOrder {
+	if orderBy == nil {
+		return fallback
+	}
+	if order, ok := sortOrders[*orderBy]; ok {
+		return order
+	}
+	return fallback
+}
+
+func pageArgs(first *int32, after *string, order storage.SortOrder) (storage.PageArgs, error) {
+	page := storage.PageArgs{First: defaultPageSize, Order: order}
+	if first != nil {
+		if *first < 1 {
+			return page, invalid("first", "размер страницы должен быть положительным")
+		}
+		page.First = int(*first)
+	}
+	if page.First > maxPageSize {
+		page.First = maxPageSize
+	}
+
+	if after != nil {
+		cursor, err := storage.DecodeCursor(*after)
+		if err != nil || cursor.Order != order {
+			return page, invalid("after", "некорректный курсор")
+		}
+		page.After = &cursor
+	}
+	return page, nil
+}
+
+func postConnection(page *storage.Page[*models.Post]) *model.PostConnection {
+	conn := &model.PostConnection{
+		Edges:    make([]*model.PostEdge, len(page.Items)),
+		PageInfo: &model.PageInfo{HasNextPage: page.HasNextPage},
+	}
+	for i, post := range page.Items {
+		conn.Edges[i] = &model.PostEdge{
+			Cursor: page.Cursors[i].Encode(),
+			Node:   dbPostToGraphQL(post),
+		}
+	}
+	if len(conn.Edges) > 0 {
+		conn.PageInfo.EndCursor = &conn.Edges[len(conn.Edges)-1].Cursor
+	}
+	return conn
+}
+
+func commentConnection(page *storage.Page[*models.Comment]) *model.CommentConnection {
+	conn := &model.CommentConnection{
+		Edges:    make([]*model.CommentEdge, len(page.Items)),
+		PageInfo: &model.PageInfo{HasNextPage: page.HasNextPage},
+	}
+	for i, comment := range page.Items {
+		conn.Edges[i] = &model.CommentEdge{
+			Cursor: page.Cursors[i].Encode(),
+			Node:   dbCommentToGraphQL(comment),
+		}
+	}
+	if len(conn.Edges) > 0 {
+		conn.PageInfo.EndCursor = &conn.Edges[len(conn.Edges)-1].Cursor
+	}
+	return conn
+}
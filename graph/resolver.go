@@ -1,9 +1,16 @@
 package graph
 
 import (
-	"sync"
+	"context"
+	"errors"
+	"log"
+	"strconv"
 
+	"github.com/Anabol1ks/ozon_tz/graph/loaders"
 	"github.com/Anabol1ks/ozon_tz/graph/model"
+	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"github.com/Anabol1ks/ozon_tz/pkg/auth"
+	"github.com/Anabol1ks/ozon_tz/pkg/pubsub"
 	"github.com/Anabol1ks/ozon_tz/pkg/storage"
 	"gorm.io/gorm"
 )
@@ -13,8 +20,189 @@ import (
 // It serves as dependency injection for your app, add any dependencies you require here.
 
 type Resolver struct {
-	DB                *gorm.DB
-	Store             storage.Storage
-	CommentObservers  map[string][]chan *model.Comment
-	CommentObserversM sync.Mutex
+	DB     *gorm.DB
+	Store  storage.Storage
+	Auth   *auth.Manager
+	PubSub pubsub.PubSub
+	// MaxReplyDepth — допустимая глубина ответов для постов без своего
+	// ограничения; 0 — без ограничения
+	MaxReplyDepth int
+}
+
+// publish рассылает событие подписчикам поста. Изменение к этому моменту
+// уже сохранено, поэтому ошибка рассылки только логируется.
+func (r *Resolver) publish(ctx context.Context, event pubsub.CommentEvent) {
+	if err := r.PubSub.Publish(ctx, event); err != nil {
+		log.Printf("Ошибка публикации события %s поста %d: %v", event.Type, event.PostID, err)
+	}
+}
+
+// finishSubscription логирует завершение подписки на события поста и передаёт
+// клиенту причину, если подписку закрыл сервер.
+func finishSubscription(ctx context.Context, sub *pubsub.Subscription, postID uint) {
+	if err := sub.Err(); err != nil {
+		log.Printf("Подписка на события поста %d закрыта: %v, потеряно событий: %d", postID, err, sub.Dropped())
+		if errors.Is(err, pubsub.ErrSlowConsumer) {
+			err = errSubscriptionOverflow
+		}
+		endSubscription(ctx, err)
+	} else if dropped := sub.Dropped(); dropped > 0 {
+		log.Printf("Подписка на события поста %d завершена, потеряно событий: %d", postID, dropped)
+	}
+}
+
+// revision загружает ревизию и проверяет, что она относится к сущности нужного типа
+func (r *Resolver) revision(ctx context.Context, id string, entityType string) (*models.Revision, error) {
+	revisionID, err := parseID("revisionID", id)
+	if err != nil {
+		return nil, err
+	}
+
+	revision, err := r.Store.GetRevision(ctx, revisionID)
+	if err != nil {
+		return nil, err
+	}
+	if revision.EntityType != entityType {
+		return nil, storage.NotFound("revision")
+	}
+	return revision, nil
+}
+
+// replyParent выбирает, к какому комментарию прикрепить ответ на parent.
+// Если ответ оказался бы глубже допустимого для поста, он прикрепляется
+// к самому глубокому разрешённому предку parent.
+func (r *Resolver) replyParent(ctx context.Context, tx storage.Storage, post *models.Post, parent *models.Comment) (*models.Comment, error) {
+	limit := r.MaxReplyDepth
+	if post.MaxReplyDepth != nil {
+		limit = *post.MaxReplyDepth
+	}
+	if limit <= 0 || parent.Depth < limit {
+		return parent, nil
+	}
+
+	// Предки перечислены от корня, поэтому у ancestors[i] глубина i
+	ancestors := storage.AncestorIDs(parent.Path)
+	return tx.GetComment(ctx, ancestors[limit-1])
+}
+
+// childrenPage загружает страницу ответов для Comment.children: after —
+// id последнего уже показанного ответа.
+func (r *Resolver) childrenPage(ctx context.Context, parentID uint, first *int32, after *string, order storage.SortOrder) ([]*models.Comment, error) {
+	page, err := pageArgs(first, nil, order)
+	if err != nil {
+		return nil, err
+	}
+	afterID, err := parseOptionalID("after", after)
+	if err != nil {
+		return nil, err
+	}
+	if afterID != nil {
+		page.AfterID = *afterID
+	}
+
+	replies, err := r.Store.GetRepliesPage(ctx, parentID, page)
+	if errors.Is(err, storage.ErrInvalidCursor) {
+		return nil, invalid("after", "последний показанный ответ не относится к этому комментарию")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return replies.Items, nil
+}
+
+func (r *Resolver) replyCounts(ctx context.Context, obj *model.Comment) (storage.ReplyCounts, error) {
+	commentID, _ := strconv.ParseUint(obj.ID, 10, 64)
+	return r.loaders(ctx).ReplyCountsByID.Load(ctx, uint(commentID))
+}
+
+// commentPostID возвращает пост комментария, прочитанный до транзакции. Транзакции,
+// меняющие комментарий, блокируют сначала его пост, затем сам комментарий — в том же
+// порядке, что и createComment, иначе встречные транзакции в PostgreSQL ждут друг
+// друга. Пост у комментария не меняется, поэтому его можно узнать заранее.
+func (r *Resolver) commentPostID(ctx context.Context, id uint) (uint, error) {
+	comment, err := r.Store.GetCommentUnscoped(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	return comment.PostID, nil
+}
+
+// loaders возвращает загрузчики текущего запроса. Если middleware не
+// подключено (подписки, тесты), создаётся отдельный набор без общего кэша:
+// собирать пачку в нём некому, поэтому он загружает ключи без ожидания.
+func (r *Resolver) loaders(ctx context.Context) *loaders.Loaders {
+	if l := loaders.For(ctx); l != nil {
+		return l
+	}
+	return loaders.NewUnbatched(ctx, r.Store)
+}
+
+// commentFeed отдаёт подписчику новые комментарии поста без повторов и пропусков.
+// Комментарии одного поста фиксируются в порядке id (см. storage.GetCommentsAfter),
+// а события о них публикуются уже после фиксации и могут прийти не по порядку.
+// Поэтому по событию догружаются все комментарии после последнего отправленного
+// lastID, а sent помнит отправленные раньше собственного события.
+type commentFeed struct {
+	store  storage.Storage
+	postID uint
+	lastID uint
+	sent   map[uint]struct{}
+}
+
+// newCommentFeed начинает ленту после afterID — последнего полученного клиентом
+// комментария — и возвращает пропущенные с тех пор комментарии.
+func (r *Resolver) newCommentFeed(ctx context.Context, postID uint, afterID *uint) (*commentFeed, []*models.Comment, error) {
+	feed := &commentFeed{store: r.Store, postID: postID, sent: make(map[uint]struct{})}
+	if afterID == nil {
+		return feed, nil, nil
+	}
+
+	after, err := r.Store.GetCommentUnscoped(ctx, *afterID)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && after.PostID != postID) {
+		return nil, nil, invalid("afterCommentID", "комментарий не найден в этом посте")
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	feed.lastID = after.ID
+	missed, err := feed.fetch(ctx)
+	return feed, missed, err
+}
+
+// created возвращает ещё не отправленные комментарии по событию о создании id.
+func (f *commentFeed) created(ctx context.Context, id uint) ([]*models.Comment, error) {
+	if _, ok := f.sent[id]; ok {
+		// Комментарий уже ушёл вместе с догруженными, его событие больше не придёт
+		delete(f.sent, id)
+		return nil, nil
+	}
+	if f.lastID == 0 || id < f.lastID {
+		// Первое событие ленты без afterCommentID или запоздавшее событие
+		comment, err := f.store.GetComment(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		f.lastID = max(f.lastID, comment.ID)
+		return []*models.Comment{comment}, nil
+	}
+
+	comments, err := f.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	delete(f.sent, id)
+	return comments, nil
+}
+
+// fetch догружает комментарии после lastID и запоминает их как отправленные
+func (f *commentFeed) fetch(ctx context.Context) ([]*models.Comment, error) {
+	comments, err := f.store.GetCommentsAfter(ctx, f.postID, f.lastID)
+	if err != nil {
+		return nil, err
+	}
+	for _, comment := range comments {
+		f.sent[comment.ID] = struct{}{}
+		f.lastID = comment.ID
+	}
+	return comments, nil
 }
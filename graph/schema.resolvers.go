@@ -7,111 +7,874 @@ package graph
 import (
 	"context"
 	"errors"
+	"log"
 	"strconv"
+	"time"
 
+	"github.com/Anabol1ks/ozon_tz/graph/loaders"
 	"github.com/Anabol1ks/ozon_tz/graph/model"
 	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"github.com/Anabol1ks/ozon_tz/pkg/auth"
+	"github.com/Anabol1ks/ozon_tz/pkg/pubsub"
+	"github.com/Anabol1ks/ozon_tz/pkg/storage"
 )
 
+// Post is the resolver for the post field.
+func (r *commentResolver) Post(ctx context.Context, obj *model.Comment) (*model.Post, error) {
+	post, err := r.loaders(ctx).PostByID.Load(ctx, obj.PostID)
+	if err != nil {
+		return nil, err
+	}
+	return dbPostToGraphQL(post), nil
+}
+
+// Author is the resolver for the author field.
+func (r *commentResolver) Author(ctx context.Context, obj *model.Comment) (*model.User, error) {
+	user, err := r.loaders(ctx).UserByID.Load(ctx, obj.AuthorID)
+	if err != nil {
+		return nil, err
+	}
+	return dbUserToGraphQL(user), nil
+}
+
+// Parent is the resolver for the parent field.
+func (r *commentResolver) Parent(ctx context.Context, obj *model.Comment) (*model.Comment, error) {
+	if obj.ParentID == nil {
+		return nil, nil
+	}
+
+	parent, err := r.loaders(ctx).CommentByID.Load(ctx, *obj.ParentID)
+	if err != nil {
+		return nil, err
+	}
+	return dbCommentToGraphQL(parent), nil
+}
+
+// ReplyTo is the resolver for the replyTo field.
+func (r *commentResolver) ReplyTo(ctx context.Context, obj *model.Comment) (*model.Comment, error) {
+	if obj.ReplyToID == nil {
+		return nil, nil
+	}
+
+	// Исходный комментарий могли удалить, ответ от этого не ломается
+	target, err := r.loaders(ctx).CommentByID.Load(ctx, *obj.ReplyToID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dbCommentToGraphQL(target), nil
+}
+
+// Ancestors is the resolver for the ancestors field.
+func (r *commentResolver) Ancestors(ctx context.Context, obj *model.Comment) ([]*model.Comment, error) {
+	ids := storage.AncestorIDs(obj.Path)
+	if len(ids) == 0 {
+		return []*model.Comment{}, nil
+	}
+
+	// Предков всех комментариев страницы загрузчик собирает в один запрос
+	comments, errs := r.loaders(ctx).CommentByID.LoadMany(ctx, ids)
+	result := make([]*model.Comment, 0, len(ids))
+	for i, comment := range comments {
+		if errors.Is(errs[i], storage.ErrNotFound) {
+			continue
+		}
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		result = append(result, dbCommentToGraphQL(comment))
+	}
+	return result, nil
+}
+
+// Descendants is the resolver for the descendants field.
+func (r *commentResolver) Descendants(ctx context.Context, obj *model.Comment, limit *int32) ([]*model.Comment, error) {
+	n, err := positiveLimit("limit", limit)
+	if err != nil {
+		return nil, err
+	}
+
+	commentID, _ := strconv.ParseUint(obj.ID, 10, 64)
+	comments, err := r.Store.GetCommentDescendants(ctx, uint(commentID), n)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Comment, len(comments))
+	for i, comment := range comments {
+		result[i] = dbCommentToGraphQL(comment)
+	}
+	return result, nil
+}
+
+// ReplyCount is the resolver for the replyCount field.
+func (r *commentResolver) ReplyCount(ctx context.Context, obj *model.Comment) (int32, error) {
+	counts, err := r.replyCounts(ctx, obj)
+	if err != nil {
+		return 0, err
+	}
+	return int32(counts.Replies), nil
+}
+
+// DescendantCount is the resolver for the descendantCount field.
+func (r *commentResolver) DescendantCount(ctx context.Context, obj *model.Comment) (int32, error) {
+	counts, err := r.replyCounts(ctx, obj)
+	if err != nil {
+		return 0, err
+	}
+	return int32(counts.Descendants), nil
+}
+
+// Children is the resolver for the children field.
+func (r *commentResolver) Children(ctx context.Context, obj *model.Comment, first *int32, after *string, orderBy *model.SortOrder) ([]*model.Comment, error) {
+	commentID, _ := strconv.ParseUint(obj.ID, 10, 64)
+	order := sortOrder(orderBy, storage.SortOldest)
+
+	var comments []*models.Comment
+	var err error
+	if after == nil {
+		var page storage.PageArgs
+		if page, err = pageArgs(first, nil, order); err != nil {
+			return nil, err
+		}
+		key := loaders.ChildrenKey{ParentID: uint(commentID), Order: order, Limit: page.First}
+		comments, err = r.loaders(ctx).ChildrenByParentID.Load(ctx, key)
+	} else {
+		comments, err = r.childrenPage(ctx, uint(commentID), first, after, order)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Comment, len(comments))
+	for i, comment := range comments {
+		result[i] = dbCommentToGraphQL(comment)
+	}
+	return result, nil
+}
+
+// Replies is the resolver for the replies field.
+func (r *commentResolver) Replies(ctx context.Context, obj *model.Comment, first *int32, after *string, orderBy *model.SortOrder) (*model.CommentConnection, error) {
+	page, err := pageArgs(first, after, sortOrder(orderBy, storage.SortOldest))
+	if err != nil {
+		return nil, err
+	}
+
+	commentID, _ := strconv.ParseUint(obj.ID, 10, 64)
+	replies, err := r.Store.GetRepliesPage(ctx, uint(commentID), page)
+	if err != nil {
+		return nil, err
+	}
+	return commentConnection(replies), nil
+}
+
+// Revisions is the resolver for the revisions field.
+func (r *commentResolver) Revisions(ctx context.Context, obj *model.Comment) ([]*model.Revision, error) {
+	if !canSeeRevisions(ctx, obj.AuthorID) {
+		return []*model.Revision{}, nil
+	}
+
+	commentID, _ := strconv.ParseUint(obj.ID, 10, 64)
+	key := loaders.RevisionKey{EntityType: models.RevisionComment, EntityID: uint(commentID)}
+	revisions, err := r.loaders(ctx).RevisionsByEntity.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Revision, len(revisions))
+	for i, revision := range revisions {
+		result[i] = dbRevisionToGraphQL(revision)
+	}
+	return result, nil
+}
+
 // CreatePost is the resolver for the createPost field.
-func (r *mutationResolver) CreatePost(ctx context.Context, title string, content string, authorID string) (*model.Post, error) {
-	authorIDUint, _ := strconv.ParseUint(authorID, 10, 64)
-	post := &models.Post{Title: title, Content: content, AuthorID: uint(authorIDUint)}
-	if err := r.Store.CreatePost(post); err != nil {
+func (r *mutationResolver) CreatePost(ctx context.Context, title string, content string, maxReplyDepth *int32) (*model.Post, error) {
+	author, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validatePost(title, content); err != nil {
+		return nil, err
+	}
+	depth, err := parseMaxReplyDepth("maxReplyDepth", maxReplyDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	post := &models.Post{Title: title, Content: content, AuthorID: author.ID, MaxReplyDepth: depth}
+	if err := r.Store.CreatePost(ctx, post); err != nil {
+		return nil, err
+	}
+	return dbPostToGraphQL(post), nil
+}
+
+// CreateComment is the resolver for the createComment field.
+func (r *mutationResolver) CreateComment(ctx context.Context, postID string, parentID *string, content string) (*model.Comment, error) {
+	author, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	postIDUint, err := parseID("postID", postID)
+	if err != nil {
+		return nil, err
+	}
+	parentIDUint, err := parseOptionalID("parentID", parentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateComment(content); err != nil {
+		return nil, err
+	}
+
+	comment := &models.Comment{
+		PostID:   postIDUint,
+		AuthorID: author.ID,
+		Content:  content,
+	}
+
+	// Пост и родитель блокируются до записи, чтобы toggleComments
+	// или удаление родителя не проскочили между проверкой и вставкой
+	err = r.Store.WithTx(ctx, func(tx storage.Storage) error {
+		post, err := tx.GetPost(ctx, postIDUint)
+		if err != nil {
+			return err
+		}
+
+		if post.DisableComments {
+			return storage.NewError(storage.ErrForbidden, "комментарии к этому сообщению отключены")
+		}
+
+		if parentIDUint != nil {
+			parent, err := tx.GetComment(ctx, *parentIDUint)
+			if errors.Is(err, storage.ErrNotFound) {
+				return storage.NewError(storage.ErrNotFound, "родительский комментарий не найден")
+			}
+			if err != nil {
+				return err
+			}
+			if parent.PostID != postIDUint {
+				return invalid("parentID", "родительский комментарий относится к другому посту")
+			}
+
+			attachTo, err := r.replyParent(ctx, tx, post, parent)
+			if err != nil {
+				return err
+			}
+			comment.ParentID = &attachTo.ID
+			if attachTo.ID != parent.ID {
+				comment.ReplyToID = &parent.ID
+			}
+		}
+
+		return tx.CreateComment(ctx, comment)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.publish(ctx, pubsub.CommentEvent{Type: pubsub.CommentCreated, PostID: comment.PostID, CommentID: comment.ID})
+
+	return dbCommentToGraphQL(comment), nil
+}
+
+// ToggleComments is the resolver for the toggleComments field.
+func (r *mutationResolver) ToggleComments(ctx context.Context, postID string, disable bool) (*model.Post, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	postIDUint, err := parseID("postID", postID)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated models.Post
+	err = r.Store.WithTx(ctx, func(tx storage.Storage) error {
+		post, err := tx.GetPost(ctx, postIDUint)
+		if err != nil {
+			return err
+		}
+
+		if post.AuthorID != user.ID {
+			return storage.NewError(storage.ErrForbidden, "Только владелец может включать/отключать комментарии")
+		}
+
+		updated = *post
+		updated.DisableComments = disable
+		return tx.UpdatePost(ctx, &updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dbPostToGraphQL(&updated), nil
+}
+
+// SetMaxReplyDepth is the resolver for the setMaxReplyDepth field.
+func (r *mutationResolver) SetMaxReplyDepth(ctx context.Context, postID string, maxDepth *int32) (*model.Post, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	postIDUint, err := parseID("postID", postID)
+	if err != nil {
+		return nil, err
+	}
+	depth, err := parseMaxReplyDepth("maxDepth", maxDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated models.Post
+	err = r.Store.WithTx(ctx, func(tx storage.Storage) error {
+		post, err := tx.GetPost(ctx, postIDUint)
+		if err != nil {
+			return err
+		}
+
+		if post.AuthorID != user.ID {
+			return storage.NewError(storage.ErrForbidden, "Только автор может менять глубину ответов")
+		}
+
+		// Меняем копию: запись из хранилища в памяти могут читать параллельно
+		updated = *post
+		updated.MaxReplyDepth = depth
+		return tx.UpdatePost(ctx, &updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dbPostToGraphQL(&updated), nil
+}
+
+// UpdatePost is the resolver for the updatePost field.
+func (r *mutationResolver) UpdatePost(ctx context.Context, id string, title *string, content *string) (*model.Post, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	postID, err := parseID("id", id)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated models.Post
+	err = r.Store.WithTx(ctx, func(tx storage.Storage) error {
+		post, err := tx.GetPost(ctx, postID)
+		if err != nil {
+			return err
+		}
+
+		if post.AuthorID != user.ID {
+			return storage.NewError(storage.ErrForbidden, "Только автор может редактировать пост")
+		}
+
+		// Меняем копию, чтобы не испортить запись в памяти, если проверка не пройдёт
+		updated = *post
+		if title != nil {
+			updated.Title = *title
+		}
+		if content != nil {
+			updated.Content = *content
+		}
+		if err := validatePost(updated.Title, updated.Content); err != nil {
+			return err
+		}
+
+		return tx.UpdatePost(ctx, &updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.publish(ctx, pubsub.CommentEvent{Type: pubsub.PostUpdated, PostID: updated.ID})
+	return dbPostToGraphQL(&updated), nil
+}
+
+// DeletePost is the resolver for the deletePost field.
+func (r *mutationResolver) DeletePost(ctx context.Context, id string) (bool, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	postID, err := parseID("id", id)
+	if err != nil {
+		return false, err
+	}
+
+	err = r.Store.WithTx(ctx, func(tx storage.Storage) error {
+		post, err := tx.GetPost(ctx, postID)
+		if err != nil {
+			return err
+		}
+
+		if post.AuthorID != user.ID {
+			return storage.NewError(storage.ErrForbidden, "Только автор может удалить пост")
+		}
+
+		return tx.DeletePost(ctx, postID)
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UpdateComment is the resolver for the updateComment field.
+func (r *mutationResolver) UpdateComment(ctx context.Context, id string, content string) (*model.Comment, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	commentID, err := parseID("id", id)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateComment(content); err != nil {
+		return nil, err
+	}
+
+	postID, err := r.commentPostID(ctx, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated models.Comment
+	err = r.Store.WithTx(ctx, func(tx storage.Storage) error {
+		if _, err := tx.GetPostUnscoped(ctx, postID); err != nil {
+			return err
+		}
+		comment, err := tx.GetComment(ctx, commentID)
+		if err != nil {
+			return err
+		}
+
+		if comment.AuthorID != user.ID {
+			return storage.NewError(storage.ErrForbidden, "Только автор может редактировать комментарий")
+		}
+		if comment.Deleted {
+			return storage.NewError(storage.ErrForbidden, "удалённый комментарий нельзя редактировать")
+		}
+
+		updated = *comment
+		now := time.Now()
+		updated.Content = content
+		updated.EditedAt = &now
+		return tx.UpdateComment(ctx, &updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.publish(ctx, pubsub.CommentEvent{Type: pubsub.CommentUpdated, PostID: updated.PostID, CommentID: updated.ID})
+	return dbCommentToGraphQL(&updated), nil
+}
+
+// DeleteComment is the resolver for the deleteComment field.
+func (r *mutationResolver) DeleteComment(ctx context.Context, id string) (bool, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	commentID, err := parseID("id", id)
+	if err != nil {
+		return false, err
+	}
+
+	postID, err := r.commentPostID(ctx, commentID)
+	if err != nil {
+		return false, err
+	}
+
+	var comment, tombstone *models.Comment
+	var removedParents []*models.Comment
+	err = r.Store.WithTx(ctx, func(tx storage.Storage) error {
+		if _, err := tx.GetPostUnscoped(ctx, postID); err != nil {
+			return err
+		}
+		comment, err = tx.GetComment(ctx, commentID)
+		if err != nil {
+			return err
+		}
+
+		if comment.AuthorID != user.ID {
+			return storage.NewError(storage.ErrForbidden, "Только автор может удалить комментарий")
+		}
+		if comment.Deleted {
+			return storage.NotFound("comment")
+		}
+
+		// Надгробия предков хранилище убирает вместе с их последним ответом
+		var parents []*models.Comment
+		for parentID := comment.ParentID; parentID != nil; {
+			parent, err := tx.GetComment(ctx, *parentID)
+			if err != nil {
+				return err
+			}
+			if !parent.Deleted {
+				break
+			}
+			snapshot := *parent
+			parents = append(parents, &snapshot)
+			parentID = parent.ParentID
+		}
+
+		tombstone, err = tx.DeleteComment(ctx, commentID)
+		if err != nil {
+			return err
+		}
+		for _, parent := range parents {
+			_, err := tx.GetComment(ctx, parent.ID)
+			if errors.Is(err, storage.ErrNotFound) {
+				removedParents = append(removedParents, parent)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			break
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	event := pubsub.CommentEvent{Type: pubsub.CommentDeleted, PostID: comment.PostID, CommentID: comment.ID}
+	if tombstone == nil {
+		// Комментария больше нет в хранилище, подписчики получат его снимок
+		removed := *comment
+		removed.Content = storage.DeletedContent
+		removed.Deleted = true
+		event.Removed = &removed
+	}
+	r.publish(ctx, event)
+	for _, parent := range removedParents {
+		r.publish(ctx, pubsub.CommentEvent{Type: pubsub.CommentDeleted, PostID: parent.PostID, CommentID: parent.ID, Removed: parent})
+	}
+	return true, nil
+}
+
+// RestorePostRevision is the resolver for the restorePostRevision field.
+func (r *mutationResolver) RestorePostRevision(ctx context.Context, revisionID string) (*model.Post, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	revision, err := r.revision(ctx, revisionID, models.RevisionPost)
+	if err != nil {
+		return nil, err
+	}
+
+	var updated models.Post
+	err = r.Store.WithTx(ctx, func(tx storage.Storage) error {
+		post, err := tx.GetPost(ctx, revision.EntityID)
+		if err != nil {
+			return err
+		}
+
+		if post.AuthorID != user.ID {
+			return storage.NewError(storage.ErrForbidden, "Только автор может восстановить версию поста")
+		}
+
+		updated = *post
+		updated.Title = revision.Title
+		updated.Content = revision.Content
+		return tx.UpdatePost(ctx, &updated)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r.publish(ctx, pubsub.CommentEvent{Type: pubsub.PostUpdated, PostID: updated.ID})
+	return dbPostToGraphQL(&updated), nil
+}
+
+// RestoreCommentRevision is the resolver for the restoreCommentRevision field.
+func (r *mutationResolver) RestoreCommentRevision(ctx context.Context, revisionID string) (*model.Comment, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
 		return nil, err
 	}
-	return dbPostToGraphQL(post), nil
-}
 
-// CreateComment is the resolver for the createComment field.
-func (r *mutationResolver) CreateComment(ctx context.Context, postID string, parentID *string, authorID string, content string) (*model.Comment, error) {
-	postIDUint, _ := strconv.ParseUint(postID, 10, 64)
-	authorIDUint, _ := strconv.ParseUint(authorID, 10, 64)
+	revision, err := r.revision(ctx, revisionID, models.RevisionComment)
+	if err != nil {
+		return nil, err
+	}
 
-	post, err := r.Store.GetPost(uint(postIDUint))
+	postID, err := r.commentPostID(ctx, revision.EntityID)
 	if err != nil {
 		return nil, err
 	}
 
-	if post.DisableComments {
-		return nil, errors.New("комментарии к этому сообщению отключены")
+	var updated models.Comment
+	err = r.Store.WithTx(ctx, func(tx storage.Storage) error {
+		if _, err := tx.GetPostUnscoped(ctx, postID); err != nil {
+			return err
+		}
+		comment, err := tx.GetComment(ctx, revision.EntityID)
+		if err != nil {
+			return err
+		}
+
+		if comment.AuthorID != user.ID {
+			return storage.NewError(storage.ErrForbidden, "Только автор может восстановить версию комментария")
+		}
+		if comment.Deleted {
+			return storage.NewError(storage.ErrForbidden, "удалённый комментарий нельзя редактировать")
+		}
+
+		updated = *comment
+		now := time.Now()
+		updated.Content = revision.Content
+		updated.EditedAt = &now
+		return tx.UpdateComment(ctx, &updated)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	comment := &models.Comment{
-		PostID:   uint(postIDUint),
-		AuthorID: uint(authorIDUint),
-		Content:  content,
+	r.publish(ctx, pubsub.CommentEvent{Type: pubsub.CommentUpdated, PostID: updated.PostID, CommentID: updated.ID})
+	return dbCommentToGraphQL(&updated), nil
+}
+
+// RestorePost is the resolver for the restorePost field.
+func (r *mutationResolver) RestorePost(ctx context.Context, id string) (*model.Post, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	postID, err := parseID("id", id)
+	if err != nil {
+		return nil, err
 	}
 
-	if parentID != nil {
-		parentIDUint, _ := strconv.ParseUint(*parentID, 10, 64)
-		// Verify parent comment exists
-		_, err := r.Store.GetComment(uint(parentIDUint))
+	var restored *models.Post
+	err = r.Store.WithTx(ctx, func(tx storage.Storage) error {
+		post, err := tx.GetPostUnscoped(ctx, postID)
 		if err != nil {
-			return nil, errors.New("родительский комментарий не найден")
+			return err
+		}
+
+		if post.AuthorID != user.ID && !user.IsAdmin {
+			return storage.NewError(storage.ErrForbidden, "Только автор может восстановить пост")
 		}
-		parentIDUintVal := uint(parentIDUint)
-		comment.ParentID = &parentIDUintVal
+
+		restored, err = tx.RestorePost(ctx, postID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return dbPostToGraphQL(restored), nil
+}
+
+// RestoreComment is the resolver for the restoreComment field.
+func (r *mutationResolver) RestoreComment(ctx context.Context, id string) (*model.Comment, error) {
+	user, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := r.Store.CreateComment(comment); err != nil {
+	commentID, err := parseID("id", id)
+	if err != nil {
 		return nil, err
 	}
 
-	r.CommentObserversM.Lock()
-	if channels, ok := r.CommentObservers[postID]; ok {
-		for _, ch := range channels {
-			select {
-			case ch <- dbCommentToGraphQL(comment):
-			default:
-			}
+	postID, err := r.commentPostID(ctx, commentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var restored *models.Comment
+	err = r.Store.WithTx(ctx, func(tx storage.Storage) error {
+		if _, err := tx.GetPostUnscoped(ctx, postID); err != nil {
+			return err
+		}
+		comment, err := tx.GetCommentUnscoped(ctx, commentID)
+		if err != nil {
+			return err
+		}
+
+		if comment.AuthorID != user.ID && !user.IsAdmin {
+			return storage.NewError(storage.ErrForbidden, "Только автор может восстановить комментарий")
+		}
+
+		// Комментарии удалённого поста возвращаются через restorePost
+		if _, err := tx.GetPost(ctx, comment.PostID); err != nil {
+			return err
 		}
+
+		restored, err = tx.RestoreComment(ctx, commentID)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
-	r.CommentObserversM.Unlock()
 
-	return dbCommentToGraphQL(comment), nil
+	r.publish(ctx, pubsub.CommentEvent{Type: pubsub.CommentUpdated, PostID: restored.PostID, CommentID: restored.ID})
+	return dbCommentToGraphQL(restored), nil
 }
 
-// ToggleComments is the resolver for the toggleComments field.
-func (r *mutationResolver) ToggleComments(ctx context.Context, postID string, disable bool, authorID string) (*model.Post, error) {
-	postIDUint, _ := strconv.ParseUint(postID, 10, 64)
-	post, err := r.Store.GetPost(uint(postIDUint))
+// DeleteUser is the resolver for the deleteUser field.
+func (r *mutationResolver) DeleteUser(ctx context.Context, id string) (bool, error) {
+	if _, err := currentAdmin(ctx); err != nil {
+		return false, err
+	}
+
+	userID, err := parseID("id", id)
 	if err != nil {
+		return false, err
+	}
+
+	if err := r.Store.DeleteUser(ctx, userID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RestoreUser is the resolver for the restoreUser field.
+func (r *mutationResolver) RestoreUser(ctx context.Context, id string) (*model.User, error) {
+	if _, err := currentAdmin(ctx); err != nil {
 		return nil, err
 	}
 
-	if strconv.FormatUint(uint64(post.AuthorID), 10) != authorID {
-		return nil, errors.New("Только владелец может включать/отключать комментарии")
+	userID, err := parseID("id", id)
+	if err != nil {
+		return nil, err
 	}
 
-	post.DisableComments = disable
-	if err := r.Store.UpdatePost(post); err != nil {
+	if err := r.Store.RestoreUser(ctx, userID); err != nil {
 		return nil, err
 	}
-	return dbPostToGraphQL(post), nil
+
+	user, err := r.Store.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return dbUserToGraphQL(user), nil
+}
+
+// Register is the resolver for the register field.
+func (r *mutationResolver) Register(ctx context.Context, username string, password string) (*model.AuthPayload, error) {
+	if err := validateUsername(username); err != nil {
+		return nil, err
+	}
+	if err := validatePassword(password); err != nil {
+		return nil, err
+	}
+
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	dbUser := &models.User{Username: username, PasswordHash: hash}
+	if err := r.Store.CreateUser(ctx, dbUser); err != nil {
+		return nil, err
+	}
+
+	return r.authPayload(dbUser)
+}
+
+// Login is the resolver for the login field.
+func (r *mutationResolver) Login(ctx context.Context, username string, password string) (*model.AuthPayload, error) {
+	dbUser, err := r.Store.GetUserByUsername(ctx, username)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, errInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := auth.CheckPassword(dbUser.PasswordHash, password); err != nil {
+		return nil, errInvalidCredentials
+	}
+
+	return r.authPayload(dbUser)
+}
+
+// Author is the resolver for the author field.
+func (r *postResolver) Author(ctx context.Context, obj *model.Post) (*model.User, error) {
+	user, err := r.loaders(ctx).UserByID.Load(ctx, obj.AuthorID)
+	if err != nil {
+		return nil, err
+	}
+	return dbUserToGraphQL(user), nil
+}
+
+// Comments is the resolver for the comments field.
+func (r *postResolver) Comments(ctx context.Context, obj *model.Post, limit *int32, offset *int32, orderBy *model.SortOrder) ([]*model.Comment, error) {
+	if err := validateLimitOffset(limit, offset); err != nil {
+		return nil, err
+	}
+
+	postID, _ := strconv.ParseUint(obj.ID, 10, 64)
+	comments, err := r.Store.GetComments(ctx, uint(postID), limit, offset, sortOrder(orderBy, storage.SortOldest))
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Comment, len(comments))
+	for i, comment := range comments {
+		result[i] = dbCommentToGraphQL(comment)
+	}
+	return result, nil
+}
+
+// Thread is the resolver for the thread field.
+func (r *postResolver) Thread(ctx context.Context, obj *model.Post, rootLimit *int32, maxDepth *int32) ([]*model.ThreadComment, error) {
+	roots, err := positiveLimit("rootLimit", rootLimit)
+	if err != nil {
+		return nil, err
+	}
+	depth, err := positiveLimit("maxDepth", maxDepth)
+	if err != nil {
+		return nil, err
+	}
+
+	postID, _ := strconv.ParseUint(obj.ID, 10, 64)
+	thread, err := r.Store.GetCommentTree(ctx, uint(postID), roots, depth)
+	if err != nil {
+		return nil, err
+	}
+	return dbThreadToGraphQL(thread), nil
 }
 
-// CreateUser is the resolver for the createUser field.
-func (r *mutationResolver) CreateUser(ctx context.Context, username string) (*model.User, error) {
-	if username == "" {
-		return nil, errors.New("username не может быть пустым")
+// Revisions is the resolver for the revisions field.
+func (r *postResolver) Revisions(ctx context.Context, obj *model.Post) ([]*model.Revision, error) {
+	if !canSeeRevisions(ctx, obj.AuthorID) {
+		return []*model.Revision{}, nil
 	}
 
-	dbUser := &models.User{Username: username}
-	if err := r.Store.CreateUser(dbUser); err != nil {
+	postID, _ := strconv.ParseUint(obj.ID, 10, 64)
+	key := loaders.RevisionKey{EntityType: models.RevisionPost, EntityID: uint(postID)}
+	revisions, err := r.loaders(ctx).RevisionsByEntity.Load(ctx, key)
+	if err != nil {
 		return nil, err
 	}
 
-	return &model.User{
-		ID:        strconv.FormatUint(uint64(dbUser.ID), 10),
-		Username:  dbUser.Username,
-		CreatedAt: dbUser.CreatedAt.String(),
-	}, nil
+	result := make([]*model.Revision, len(revisions))
+	for i, revision := range revisions {
+		result[i] = dbRevisionToGraphQL(revision)
+	}
+	return result, nil
 }
 
 // GetPosts is the resolver for the getPosts field.
-func (r *queryResolver) GetPosts(ctx context.Context) ([]*model.Post, error) {
-	posts, err := r.Store.GetPosts()
+func (r *queryResolver) GetPosts(ctx context.Context, orderBy *model.SortOrder) ([]*model.Post, error) {
+	posts, err := r.Store.GetPosts(ctx, sortOrder(orderBy, storage.SortNewest))
 	if err != nil {
 		return nil, err
 	}
@@ -125,8 +888,12 @@ func (r *queryResolver) GetPosts(ctx context.Context) ([]*model.Post, error) {
 
 // GetPost is the resolver for the getPost field.
 func (r *queryResolver) GetPost(ctx context.Context, id string) (*model.Post, error) {
-	postID, _ := strconv.ParseUint(id, 10, 64)
-	post, err := r.Store.GetPost(uint(postID))
+	postID, err := parseID("id", id)
+	if err != nil {
+		return nil, err
+	}
+
+	post, err := r.Store.GetPost(ctx, postID)
 	if err != nil {
 		return nil, err
 	}
@@ -134,13 +901,16 @@ func (r *queryResolver) GetPost(ctx context.Context, id string) (*model.Post, er
 }
 
 // GetComments is the resolver for the getComments field.
-func (r *queryResolver) GetComments(ctx context.Context, postID string, limit *int32, offset *int32) ([]*model.Comment, error) {
-	postIDUint, err := strconv.ParseUint(postID, 10, 64)
+func (r *queryResolver) GetComments(ctx context.Context, postID string, limit *int32, offset *int32, orderBy *model.SortOrder) ([]*model.Comment, error) {
+	postIDUint, err := parseID("postID", postID)
 	if err != nil {
 		return nil, err
 	}
+	if err := validateLimitOffset(limit, offset); err != nil {
+		return nil, err
+	}
 
-	comments, err := r.Store.GetComments(uint(postIDUint), limit, offset)
+	comments, err := r.Store.GetComments(ctx, postIDUint, limit, offset, sortOrder(orderBy, storage.SortOldest))
 	if err != nil {
 		return nil, err
 	}
@@ -153,91 +923,226 @@ func (r *queryResolver) GetComments(ctx context.Context, postID string, limit *i
 	return result, nil
 }
 
+// Posts is the resolver for the posts field.
+func (r *queryResolver) Posts(ctx context.Context, first *int32, after *string, orderBy *model.SortOrder) (*model.PostConnection, error) {
+	page, err := pageArgs(first, after, sortOrder(orderBy, storage.SortNewest))
+	if err != nil {
+		return nil, err
+	}
+
+	posts, err := r.Store.GetPostsPage(ctx, page)
+	if err != nil {
+		return nil, err
+	}
+	return postConnection(posts), nil
+}
+
+// Comments is the resolver for the comments field.
+func (r *queryResolver) Comments(ctx context.Context, postID string, first *int32, after *string, orderBy *model.SortOrder) (*model.CommentConnection, error) {
+	postIDUint, err := parseID("postID", postID)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := pageArgs(first, after, sortOrder(orderBy, storage.SortOldest))
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := r.Store.GetCommentsPage(ctx, postIDUint, page)
+	if err != nil {
+		return nil, err
+	}
+	return commentConnection(comments), nil
+}
+
+// DeletedPosts is the resolver for the deletedPosts field.
+func (r *queryResolver) DeletedPosts(ctx context.Context) ([]*model.Post, error) {
+	if _, err := currentAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	posts, err := r.Store.GetDeletedPosts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Post, len(posts))
+	for i, post := range posts {
+		result[i] = dbPostToGraphQL(post)
+	}
+	return result, nil
+}
+
+// DeletedComments is the resolver for the deletedComments field.
+func (r *queryResolver) DeletedComments(ctx context.Context, postID string) ([]*model.Comment, error) {
+	if _, err := currentAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	postIDUint, err := parseID("postID", postID)
+	if err != nil {
+		return nil, err
+	}
+
+	comments, err := r.Store.GetDeletedComments(ctx, postIDUint)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Comment, len(comments))
+	for i, comment := range comments {
+		result[i] = dbCommentToGraphQL(comment)
+	}
+	return result, nil
+}
+
+// Author is the resolver for the author field.
+func (r *revisionResolver) Author(ctx context.Context, obj *model.Revision) (*model.User, error) {
+	user, err := r.loaders(ctx).UserByID.Load(ctx, obj.AuthorID)
+	if err != nil {
+		return nil, err
+	}
+	return dbUserToGraphQL(user), nil
+}
+
 // OnNewComment is the resolver for the onNewComment field.
-func (r *subscriptionResolver) OnNewComment(ctx context.Context, postID string) (<-chan *model.Comment, error) {
-	commentChan := make(chan *model.Comment, 1)
+func (r *subscriptionResolver) OnNewComment(ctx context.Context, postID string, afterCommentID *string) (<-chan *model.Comment, error) {
+	postIDUint, err := parseID("postID", postID)
+	if err != nil {
+		return nil, err
+	}
 
-	postKey := postID
+	afterID, err := parseOptionalID("afterCommentID", afterCommentID)
+	if err != nil {
+		return nil, err
+	}
 
-	r.CommentObserversM.Lock()
-	r.CommentObservers[postKey] = append(r.CommentObservers[postKey], commentChan)
-	r.CommentObserversM.Unlock()
+	// Подписываемся до чтения пропущенных комментариев, чтобы не потерять
+	// созданные между выборкой и подпиской; повторы отсекает лента.
+	sub, err := r.PubSub.Subscribe(ctx, postIDUint)
+	if err != nil {
+		return nil, err
+	}
 
-	go func() {
-		<-ctx.Done()
+	feed, missed, err := r.newCommentFeed(ctx, postIDUint, afterID)
+	if err != nil {
+		return nil, err
+	}
 
-		r.CommentObserversM.Lock()
-		defer r.CommentObserversM.Unlock()
+	commentChan := make(chan *model.Comment, 1)
+	go func() {
+		defer close(commentChan)
+		defer finishSubscription(ctx, sub, postIDUint)
 
-		// Фильтруем каналы, удаляя текущий
-		channels := r.CommentObservers[postKey]
-		newChannels := make([]chan *model.Comment, 0, len(channels))
-		for _, ch := range channels {
-			if ch != commentChan {
-				newChannels = append(newChannels, ch)
+		send := func(comments ...*models.Comment) bool {
+			for _, comment := range comments {
+				select {
+				case commentChan <- dbCommentToGraphQL(comment):
+				case <-ctx.Done():
+					return false
+				}
 			}
+			return true
 		}
 
-		// Если больше нет подписчиков, удаляем ключ
-		if len(newChannels) > 0 {
-			r.CommentObservers[postKey] = newChannels
-		} else {
-			delete(r.CommentObservers, postKey)
+		if !send(missed...) {
+			return
 		}
 
-		close(commentChan) // Закрываем канал
+		for event := range sub.Events() {
+			switch event.Type {
+			case pubsub.PostUpdated:
+				continue
+			case pubsub.CommentCreated:
+				created, err := feed.created(ctx, event.CommentID)
+				if err != nil {
+					log.Printf("Ошибка загрузки новых комментариев поста %d: %v", postIDUint, err)
+					continue
+				}
+				if !send(created...) {
+					return
+				}
+				continue
+			}
+
+			comment := event.Removed
+			if comment == nil {
+				var err error
+				comment, err = r.Store.GetComment(ctx, event.CommentID)
+				if err != nil {
+					log.Printf("Ошибка загрузки комментария %d: %v", event.CommentID, err)
+					continue
+				}
+			}
+			if !send(comment) {
+				return
+			}
+		}
 	}()
 
 	return commentChan, nil
 }
 
+// OnPostUpdated is the resolver for the onPostUpdated field.
+func (r *subscriptionResolver) OnPostUpdated(ctx context.Context, postID string) (<-chan *model.Post, error) {
+	postIDUint, err := parseID("postID", postID)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := r.PubSub.Subscribe(ctx, postIDUint)
+	if err != nil {
+		return nil, err
+	}
+
+	postChan := make(chan *model.Post, 1)
+	go func() {
+		defer close(postChan)
+		defer finishSubscription(ctx, sub, postIDUint)
+
+		for event := range sub.Events() {
+			if event.Type != pubsub.PostUpdated {
+				continue
+			}
+
+			post, err := r.Store.GetPost(ctx, event.PostID)
+			if err != nil {
+				log.Printf("Ошибка загрузки поста %d: %v", event.PostID, err)
+				continue
+			}
+			select {
+			case postChan <- dbPostToGraphQL(post):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return postChan, nil
+}
+
+// Comment returns CommentResolver implementation.
+func (r *Resolver) Comment() CommentResolver { return &commentResolver{r} }
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 
+// Post returns PostResolver implementation.
+func (r *Resolver) Post() PostResolver { return &postResolver{r} }
+
 // Query returns QueryResolver implementation.
 func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
 
+// Revision returns RevisionResolver implementation.
+func (r *Resolver) Revision() RevisionResolver { return &revisionResolver{r} }
+
 // Subscription returns SubscriptionResolver implementation.
 func (r *Resolver) Subscription() SubscriptionResolver { return &subscriptionResolver{r} }
 
+type commentResolver struct{ *Resolver }
 type mutationResolver struct{ *Resolver }
+type postResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type revisionResolver struct{ *Resolver }
 type subscriptionResolver struct{ *Resolver }
-
-type CommentResolver interface {
-	Children(ctx context.Context, obj *model.Comment) ([]*model.Comment, error)
-}
-
-func (r *commentResolver) Children(ctx context.Context, obj *model.Comment) ([]*model.Comment, error) {
-	commentID, _ := strconv.ParseUint(obj.ID, 10, 64)
-	comments, err := r.Store.GetCommentChildren(uint(commentID))
-	if err != nil {
-		return nil, err
-	}
-
-	result := make([]*model.Comment, len(comments))
-	for i, comment := range comments {
-		result[i] = dbCommentToGraphQL(comment)
-	}
-	return result, nil
-}
-func (r *Resolver) Comment() CommentResolver { return &commentResolver{r} }
-
-type commentResolver struct{ *Resolver }
-
-func dbPostToGraphQL(dbPost *models.Post) *model.Post {
-	return &model.Post{
-		ID:              strconv.FormatUint(uint64(dbPost.ID), 10),
-		Title:           dbPost.Title,
-		Content:         dbPost.Content,
-		DisableComments: dbPost.DisableComments,
-		CreatedAt:       dbPost.CreatedAt.String(),
-	}
-}
-func dbCommentToGraphQL(dbComment *models.Comment) *model.Comment {
-	return &model.Comment{
-		ID:        strconv.FormatUint(uint64(dbComment.ID), 10),
-		Content:   dbComment.Content,
-		CreatedAt: dbComment.CreatedAt.String(),
-	}
-}
@@ -3,10 +3,20 @@ package graph
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/Anabol1ks/ozon_tz/graph/loaders"
 	"github.com/Anabol1ks/ozon_tz/graph/model"
 	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"github.com/Anabol1ks/ozon_tz/pkg/auth"
+	"github.com/Anabol1ks/ozon_tz/pkg/pubsub"
 	"github.com/Anabol1ks/ozon_tz/pkg/storage"
 	"github.com/glebarez/sqlite"
 	"github.com/stretchr/testify/assert"
@@ -19,7 +29,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 		t.Fatalf("Failed to connect to test database: %v", err)
 	}
 
-	err = db.AutoMigrate(&models.User{}, &models.Post{}, &models.Comment{})
+	err = db.AutoMigrate(&models.User{}, &models.Post{}, &models.Comment{}, &models.Revision{})
 	if err != nil {
 		t.Fatalf("Failed to migrate test database: %v", err)
 	}
@@ -27,22 +37,75 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	return db
 }
 
-func TestCreateUser(t *testing.T) {
+// register регистрирует пользователя и возвращает контекст, аутентифицированный от его имени
+func register(t *testing.T, resolver *Resolver, username string) (context.Context, *model.User) {
+	payload, err := (&mutationResolver{resolver}).Register(context.Background(), username, "password123")
+	if err != nil {
+		t.Fatalf("Failed to register user: %v", err)
+	}
+
+	user, err := resolver.Store.GetUserByUsername(context.Background(), username)
+	if err != nil {
+		t.Fatalf("Failed to load registered user: %v", err)
+	}
+	return auth.WithUser(context.Background(), user), payload.User
+}
+
+func TestRegisterAndLogin(t *testing.T) {
 	db := setupTestDB(t)
 	resolver := &Resolver{
 		DB:    db,
 		Store: storage.NewMemoryStorage(),
+		Auth:  auth.NewManager("test-secret", time.Hour),
 	}
 	mutation := &mutationResolver{resolver}
 	ctx := context.Background()
 
-	user, err := mutation.CreateUser(ctx, "testuser")
+	payload, err := mutation.Register(ctx, "testuser", "password123")
 	assert.NoError(t, err)
-	assert.NotNil(t, user)
-	assert.Equal(t, "testuser", user.Username)
+	assert.Equal(t, "testuser", payload.User.Username)
+	assert.NotEmpty(t, payload.Token)
 
-	_, err = mutation.CreateUser(ctx, "")
+	userID, err := resolver.Auth.ParseToken(payload.Token)
+	assert.NoError(t, err)
+	assert.Equal(t, payload.User.ID, strconv.FormatUint(uint64(userID), 10))
+
+	_, err = mutation.Register(ctx, "", "password123")
+	assert.Error(t, err)
+	_, err = mutation.Register(ctx, "shortpass", "123")
 	assert.Error(t, err)
+	_, err = mutation.Register(ctx, "testuser", "password123")
+	assert.Error(t, err)
+
+	loggedIn, err := mutation.Login(ctx, "testuser", "password123")
+	assert.NoError(t, err)
+	assert.Equal(t, payload.User.ID, loggedIn.User.ID)
+
+	_, err = mutation.Login(ctx, "testuser", "wrong-password")
+	assert.Error(t, err)
+	_, err = mutation.Login(ctx, "nobody", "password123")
+	assert.Error(t, err)
+}
+
+func TestMutationsRequireAuth(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+
+	authCtx, _ := register(t, resolver, "testuser")
+	post, err := mutation.CreatePost(authCtx, "Test Post", "Content", nil)
+	assert.NoError(t, err)
+
+	anon := context.Background()
+	_, err = mutation.CreatePost(anon, "Test Post", "Content", nil)
+	assert.ErrorIs(t, err, errUnauthenticated)
+	_, err = mutation.CreateComment(anon, post.ID, nil, "Comment")
+	assert.ErrorIs(t, err, errUnauthenticated)
+	_, err = mutation.ToggleComments(anon, post.ID, true)
+	assert.ErrorIs(t, err, errUnauthenticated)
 }
 
 func TestCreateAndGetPost(t *testing.T) {
@@ -50,13 +113,13 @@ func TestCreateAndGetPost(t *testing.T) {
 	resolver := &Resolver{
 		DB:    db,
 		Store: storage.NewMemoryStorage(),
+		Auth:  auth.NewManager("test-secret", time.Hour),
 	}
 	mutation := &mutationResolver{resolver}
 	query := &queryResolver{resolver}
-	ctx := context.Background()
 
-	user, _ := mutation.CreateUser(ctx, "testuser")
-	post, err := mutation.CreatePost(ctx, "Test Title", "Test Content", user.ID)
+	ctx, _ := register(t, resolver, "testuser")
+	post, err := mutation.CreatePost(ctx, "Test Title", "Test Content", nil)
 	assert.NoError(t, err)
 	assert.NotNil(t, post)
 	assert.Equal(t, "Test Title", post.Title)
@@ -69,70 +132,582 @@ func TestCreateAndGetPost(t *testing.T) {
 func TestCreateComment(t *testing.T) {
 	db := setupTestDB(t)
 	resolver := &Resolver{
-		DB:               db,
-		Store:            storage.NewMemoryStorage(),
-		CommentObservers: make(map[string][]chan *model.Comment),
+		DB:     db,
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
 	}
 	mutation := &mutationResolver{resolver}
-	ctx := context.Background()
 
-	user, _ := mutation.CreateUser(ctx, "testuser")
-	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", user.ID)
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
 
-	comment, err := mutation.CreateComment(ctx, post.ID, nil, user.ID, "Test Comment")
+	comment, err := mutation.CreateComment(ctx, post.ID, nil, "Test Comment")
 	assert.NoError(t, err)
 	assert.NotNil(t, comment)
 	assert.Equal(t, "Test Comment", comment.Content)
 
-	_, err = mutation.ToggleComments(ctx, post.ID, true, user.ID)
+	other, _ := mutation.CreatePost(ctx, "Other Post", "Content", nil)
+	_, err = mutation.CreateComment(ctx, other.ID, &comment.ID, "Reply")
+	assert.ErrorIs(t, err, storage.ErrValidation)
+	assert.Contains(t, err.Error(), "родительский комментарий относится к другому посту")
+
+	_, err = mutation.ToggleComments(ctx, post.ID, true)
 	assert.NoError(t, err)
 
-	_, err = mutation.CreateComment(ctx, post.ID, nil, user.ID, "Test Comment")
+	_, err = mutation.CreateComment(ctx, post.ID, nil, "Test Comment")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "комментарии к этому сообщению отключены")
 }
 
+func TestOnNewComment(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	subscription := &subscriptionResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+	other, _ := mutation.CreatePost(ctx, "Other Post", "Content", nil)
+
+	subCtx, cancel := context.WithCancel(context.Background())
+	comments, err := subscription.OnNewComment(subCtx, post.ID, nil)
+	assert.NoError(t, err)
+
+	_, err = mutation.CreateComment(ctx, other.ID, nil, "Чужой комментарий")
+	assert.NoError(t, err)
+	created, err := mutation.CreateComment(ctx, post.ID, nil, "Новый комментарий")
+	assert.NoError(t, err)
+
+	select {
+	case comment := <-comments:
+		assert.Equal(t, created.ID, comment.ID)
+		assert.Equal(t, "Новый комментарий", comment.Content)
+	case <-time.After(time.Second):
+		t.Fatal("комментарий не доставлен подписчику")
+	}
+
+	cancel()
+	select {
+	case _, ok := <-comments:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("канал подписки не закрыт после отмены")
+	}
+}
+
+func TestOnNewCommentResume(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	subscription := &subscriptionResolver{resolver}
+
+	ctx, user := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+
+	_, _ = mutation.CreateComment(ctx, post.ID, nil, "Прочитанный раньше")
+	seen, _ := mutation.CreateComment(ctx, post.ID, nil, "Прочитанный")
+	missed1, _ := mutation.CreateComment(ctx, post.ID, nil, "Пропущенный 1")
+	missed2, _ := mutation.CreateComment(ctx, post.ID, &missed1.ID, "Пропущенный ответ")
+
+	subCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	comments, err := subscription.OnNewComment(subCtx, post.ID, &seen.ID)
+	assert.NoError(t, err)
+
+	live, _ := mutation.CreateComment(ctx, post.ID, nil, "Новый")
+
+	// События публикуются после фиксации и могут прийти не по порядку:
+	// по событию о более позднем комментарии приходит и пропущенный перед ним,
+	// а запоздавшее событие ничего не повторяет
+	postID, _ := strconv.ParseUint(post.ID, 10, 64)
+	userID, _ := strconv.ParseUint(user.ID, 10, 64)
+	first := &models.Comment{PostID: uint(postID), AuthorID: uint(userID), Content: "Первый"}
+	second := &models.Comment{PostID: uint(postID), AuthorID: uint(userID), Content: "Второй"}
+	assert.NoError(t, resolver.Store.CreateComment(ctx, first))
+	assert.NoError(t, resolver.Store.CreateComment(ctx, second))
+	for _, comment := range []*models.Comment{second, first} {
+		resolver.publish(ctx, pubsub.CommentEvent{Type: pubsub.CommentCreated, PostID: comment.PostID, CommentID: comment.ID})
+	}
+	last, _ := mutation.CreateComment(ctx, post.ID, nil, "Последний")
+
+	want := []string{missed1.ID, missed2.ID, live.ID, strconv.Itoa(int(first.ID)), strconv.Itoa(int(second.ID)), last.ID}
+	var ids []string
+	for len(ids) < len(want) {
+		select {
+		case comment := <-comments:
+			ids = append(ids, comment.ID)
+		case <-time.After(time.Second):
+			t.Fatalf("получены не все комментарии: %v", ids)
+		}
+	}
+	assert.Equal(t, want, ids)
+
+	select {
+	case comment := <-comments:
+		t.Fatalf("лишний комментарий %s", comment.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	badAfter := "abc"
+	_, err = subscription.OnNewComment(subCtx, post.ID, &badAfter)
+	assert.ErrorIs(t, err, storage.ErrValidation)
+
+	unknownAfter := "100000"
+	_, err = subscription.OnNewComment(subCtx, post.ID, &unknownAfter)
+	assert.ErrorIs(t, err, storage.ErrValidation)
+}
+
+func TestOnPostUpdated(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	subscription := &subscriptionResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "V1", "Content", nil)
+
+	subCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	posts, err := subscription.OnPostUpdated(subCtx, post.ID)
+	assert.NoError(t, err)
+	comments, err := subscription.OnNewComment(subCtx, post.ID, nil)
+	assert.NoError(t, err)
+
+	receive := func(what string) *model.Post {
+		t.Helper()
+		select {
+		case got := <-posts:
+			return got
+		case <-time.After(time.Second):
+			t.Fatalf("%s не доставлена подписчику", what)
+			return nil
+		}
+	}
+
+	// Комментарии в подписку на пост не попадают
+	_, err = mutation.CreateComment(ctx, post.ID, nil, "Комментарий")
+	assert.NoError(t, err)
+	title := "V2"
+	_, err = mutation.UpdatePost(ctx, post.ID, &title, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "V2", receive("правка").Title)
+
+	revisions, _ := (&postResolver{resolver}).Revisions(ctx, post)
+	_, err = mutation.RestorePostRevision(ctx, revisions[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "V1", receive("восстановленная версия").Title)
+
+	// Подписчики комментариев не получают правок поста: следом за первым
+	// комментарием сразу приходит второй
+	_, err = mutation.CreateComment(ctx, post.ID, nil, "Второй")
+	assert.NoError(t, err)
+	for _, want := range []string{"Комментарий", "Второй"} {
+		select {
+		case comment := <-comments:
+			assert.Equal(t, want, comment.Content)
+		case <-time.After(time.Second):
+			t.Fatal("комментарий не доставлен подписчику")
+		}
+	}
+}
+
+func TestOnNewCommentOverflowError(t *testing.T) {
+	events := pubsub.NewMemory(pubsub.Options{QueueSize: 1, Overflow: pubsub.Disconnect})
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: events,
+	}
+	mutation := &mutationResolver{resolver}
+	subscription := &subscriptionResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+	comment, _ := mutation.CreateComment(ctx, post.ID, nil, "Комментарий")
+
+	// Контекст операции, как его готовит расширение сервера
+	var opCtx context.Context
+	SubscriptionErrors{}.InterceptOperation(context.Background(), func(ctx context.Context) graphql.ResponseHandler {
+		opCtx = ctx
+		return nil
+	})
+	subCtx, cancel := context.WithCancel(opCtx)
+	defer cancel()
+	comments, err := subscription.OnNewComment(subCtx, post.ID, nil)
+	assert.NoError(t, err)
+
+	// Клиент не читает: одно событие в канале резолвера, одно ждёт отправки,
+	// одно в очереди — следующее переполняет её
+	postID, _ := strconv.ParseUint(post.ID, 10, 64)
+	commentID, _ := strconv.ParseUint(comment.ID, 10, 64)
+	for i := 0; i < 5; i++ {
+		_ = events.Publish(ctx, pubsub.CommentEvent{Type: pubsub.CommentUpdated, PostID: uint(postID), CommentID: uint(commentID)})
+	}
+	for range comments {
+	}
+
+	respCtx := graphql.WithResponseContext(opCtx, ErrorPresenter, nil)
+	end := func(context.Context) *graphql.Response { return nil }
+	resp := SubscriptionErrors{}.InterceptResponse(respCtx, end)
+	if assert.NotNil(t, resp) && assert.Len(t, resp.Errors, 1) {
+		assert.Equal(t, errSubscriptionOverflow.Error(), resp.Errors[0].Message)
+		assert.Equal(t, "SLOW_CONSUMER", resp.Errors[0].Extensions["code"])
+	}
+	assert.Nil(t, SubscriptionErrors{}.InterceptResponse(graphql.WithResponseContext(opCtx, ErrorPresenter, nil), end),
+		"после ошибки поток завершается")
+}
+
 func TestToggleComments(t *testing.T) {
 	db := setupTestDB(t)
 	resolver := &Resolver{
 		DB:    db,
 		Store: storage.NewMemoryStorage(),
+		Auth:  auth.NewManager("test-secret", time.Hour),
 	}
 	mutation := &mutationResolver{resolver}
-	ctx := context.Background()
 
-	user, _ := mutation.CreateUser(ctx, "testuser")
-	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", user.ID)
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
 
-	updatedPost, err := mutation.ToggleComments(ctx, post.ID, true, user.ID)
+	updatedPost, err := mutation.ToggleComments(ctx, post.ID, true)
 	assert.NoError(t, err)
 	assert.True(t, updatedPost.DisableComments)
 
-	updatedPost, err = mutation.ToggleComments(ctx, post.ID, false, user.ID)
+	updatedPost, err = mutation.ToggleComments(ctx, post.ID, false)
 	assert.NoError(t, err)
 	assert.False(t, updatedPost.DisableComments)
 
-	wrongUser, _ := mutation.CreateUser(ctx, "wronguser")
-	_, err = mutation.ToggleComments(ctx, post.ID, true, wrongUser.ID)
+	wrongCtx, _ := register(t, resolver, "wronguser")
+	_, err = mutation.ToggleComments(wrongCtx, post.ID, true)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "Только владелец может включать/отключать комментарии")
 }
 
+func TestUpdateAndDeletePost(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	query := &queryResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Old Title", "Old Content", nil)
+	comment, _ := mutation.CreateComment(ctx, post.ID, nil, "Comment")
+	reply, _ := mutation.CreateComment(ctx, post.ID, &comment.ID, "Reply")
+
+	newTitle := "New Title"
+	updated, err := mutation.UpdatePost(ctx, post.ID, &newTitle, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "New Title", updated.Title)
+	assert.Equal(t, "Old Content", updated.Content)
+
+	empty := ""
+	_, err = mutation.UpdatePost(ctx, post.ID, nil, &empty)
+	assert.ErrorIs(t, err, storage.ErrValidation)
+	fetched, _ := query.GetPost(ctx, post.ID)
+	assert.Equal(t, "Old Content", fetched.Content)
+
+	otherCtx, _ := register(t, resolver, "otheruser")
+	_, err = mutation.UpdatePost(otherCtx, post.ID, &newTitle, nil)
+	assert.ErrorIs(t, err, storage.ErrForbidden)
+	_, err = mutation.DeletePost(otherCtx, post.ID)
+	assert.ErrorIs(t, err, storage.ErrForbidden)
+
+	deleted, err := mutation.DeletePost(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+
+	_, err = query.GetPost(ctx, post.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	for _, id := range []string{comment.ID, reply.ID} {
+		commentID, _ := strconv.ParseUint(id, 10, 64)
+		_, err = resolver.Store.GetComment(ctx, uint(commentID))
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	}
+
+	_, err = mutation.DeletePost(ctx, post.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestUpdateAndDeleteComment(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	subscription := &subscriptionResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+	root, _ := mutation.CreateComment(ctx, post.ID, nil, "Root")
+	parent, _ := mutation.CreateComment(ctx, post.ID, &root.ID, "Parent")
+	reply, _ := mutation.CreateComment(ctx, post.ID, &parent.ID, "Reply")
+
+	subCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := subscription.OnNewComment(subCtx, post.ID, nil)
+	assert.NoError(t, err)
+	next := func() *model.Comment {
+		select {
+		case comment := <-events:
+			return comment
+		case <-time.After(time.Second):
+			t.Fatal("событие не доставлено подписчику")
+			return nil
+		}
+	}
+
+	edited, err := mutation.UpdateComment(ctx, parent.ID, "Edited")
+	assert.NoError(t, err)
+	assert.Equal(t, "Edited", edited.Content)
+	assert.NotNil(t, edited.EditedAt)
+	assert.Equal(t, "Edited", next().Content)
+
+	otherCtx, _ := register(t, resolver, "otheruser")
+	_, err = mutation.UpdateComment(otherCtx, parent.ID, "Hijack")
+	assert.ErrorIs(t, err, storage.ErrForbidden)
+	_, err = mutation.DeleteComment(otherCtx, parent.ID)
+	assert.ErrorIs(t, err, storage.ErrForbidden)
+
+	// У комментария есть ответ, поэтому он остаётся в ветке как надгробие
+	ok, err := mutation.DeleteComment(ctx, parent.ID)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	tombstone := next()
+	assert.True(t, tombstone.Deleted)
+	assert.Equal(t, storage.DeletedContent, tombstone.Content)
+
+	parentID, _ := strconv.ParseUint(parent.ID, 10, 64)
+	stored, err := resolver.Store.GetComment(ctx, uint(parentID))
+	assert.NoError(t, err)
+	assert.True(t, stored.Deleted)
+	_, err = mutation.UpdateComment(ctx, parent.ID, "Again")
+	assert.ErrorIs(t, err, storage.ErrForbidden)
+
+	// Последний ответ удаляется полностью и забирает с собой надгробие родителя
+	_, err = mutation.DeleteComment(ctx, reply.ID)
+	assert.NoError(t, err)
+	removed := next()
+	assert.Equal(t, reply.ID, removed.ID)
+	assert.True(t, removed.Deleted)
+	removed = next()
+	assert.Equal(t, parent.ID, removed.ID, "подписчики узнают и об убранном надгробии")
+	assert.True(t, removed.Deleted)
+
+	_, err = resolver.Store.GetComment(ctx, uint(parentID))
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// Живой корень остаётся, событий о нём нет
+	created, _ := mutation.CreateComment(ctx, post.ID, &root.ID, "Next")
+	assert.Equal(t, created.ID, next().ID)
+}
+
+func TestRevisions(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	postResolver := &postResolver{resolver}
+	commentResolver := &commentResolver{resolver}
+	ctx, author := register(t, resolver, "testuser")
+
+	post, _ := mutation.CreatePost(ctx, "V1", "Content 1", nil)
+	_, _ = mutation.ToggleComments(ctx, post.ID, true)
+	_, _ = mutation.ToggleComments(ctx, post.ID, false)
+	title, content := "V2", "Content 2"
+	_, err := mutation.UpdatePost(ctx, post.ID, &title, &content)
+	assert.NoError(t, err)
+	title = "V3"
+	post, err = mutation.UpdatePost(ctx, post.ID, &title, nil)
+	assert.NoError(t, err)
+
+	// Переключение комментариев не меняет текст и не создаёт ревизий
+	revisions, err := postResolver.Revisions(ctx, post)
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, "V2", *revisions[0].Title)
+	assert.Equal(t, "V1", *revisions[1].Title)
+	assert.Equal(t, "Content 1", revisions[1].Content)
+
+	revisionAuthor, err := (&revisionResolver{resolver}).Author(ctx, revisions[1])
+	assert.NoError(t, err)
+	assert.Equal(t, author.ID, revisionAuthor.ID)
+
+	otherCtx, _ := register(t, resolver, "otheruser")
+	_, err = mutation.RestorePostRevision(otherCtx, revisions[1].ID)
+	assert.ErrorIs(t, err, storage.ErrForbidden)
+
+	restored, err := mutation.RestorePostRevision(ctx, revisions[1].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "V1", restored.Title)
+	assert.Equal(t, "Content 1", restored.Content)
+	revisions, _ = postResolver.Revisions(ctx, restored)
+	assert.Len(t, revisions, 3)
+	assert.Equal(t, "V3", *revisions[0].Title)
+
+	comment, _ := mutation.CreateComment(ctx, post.ID, nil, "Original")
+	_, _ = mutation.CreateComment(ctx, post.ID, &comment.ID, "Reply")
+	comment, _ = mutation.UpdateComment(ctx, comment.ID, "Edited")
+	commentRevisions, err := commentResolver.Revisions(ctx, comment)
+	assert.NoError(t, err)
+	assert.Len(t, commentRevisions, 1)
+	assert.Nil(t, commentRevisions[0].Title)
+
+	// Ревизию комментария нельзя применить к посту
+	_, err = mutation.RestorePostRevision(ctx, commentRevisions[0].ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	restoredComment, err := mutation.RestoreCommentRevision(ctx, commentRevisions[0].ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Original", restoredComment.Content)
+
+	// Текст удалённого комментария с ответами остаётся в истории
+	_, err = mutation.DeleteComment(ctx, comment.ID)
+	assert.NoError(t, err)
+	commentRevisions, _ = commentResolver.Revisions(ctx, comment)
+	assert.Len(t, commentRevisions, 3)
+	assert.Equal(t, "Original", commentRevisions[0].Content)
+
+	// История видна только автору и администратору
+	for _, reader := range []context.Context{otherCtx, context.Background()} {
+		hidden, err := commentResolver.Revisions(reader, comment)
+		assert.NoError(t, err)
+		assert.Empty(t, hidden)
+		hidden, err = postResolver.Revisions(reader, restored)
+		assert.NoError(t, err)
+		assert.Empty(t, hidden)
+	}
+	admin, _ := auth.UserFromContext(otherCtx)
+	admin.IsAdmin = true
+	commentRevisions, err = commentResolver.Revisions(otherCtx, comment)
+	assert.NoError(t, err)
+	assert.Len(t, commentRevisions, 3)
+}
+
+func TestSoftDeleteAndRestore(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	query := &queryResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	adminCtx, _ := register(t, resolver, "admin")
+	admin, _ := auth.UserFromContext(adminCtx)
+	admin.IsAdmin = true
+
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+	earlier, _ := mutation.CreateComment(ctx, post.ID, nil, "Удалён раньше поста")
+	parent, _ := mutation.CreateComment(ctx, post.ID, nil, "Parent")
+	reply, _ := mutation.CreateComment(ctx, post.ID, &parent.ID, "Reply")
+
+	// Удаление ответа убирает и надгробие родителя, восстановление возвращает оба
+	_, _ = mutation.DeleteComment(ctx, parent.ID)
+	_, err := mutation.DeleteComment(ctx, reply.ID)
+	assert.NoError(t, err)
+	comments, _ := query.GetComments(ctx, post.ID, nil, nil, nil)
+	assert.Len(t, comments, 1)
+
+	_, err = query.DeletedComments(ctx, post.ID)
+	assert.ErrorIs(t, err, storage.ErrForbidden)
+	deleted, err := query.DeletedComments(adminCtx, post.ID)
+	assert.NoError(t, err)
+	assert.Len(t, deleted, 2)
+	assert.NotNil(t, deleted[0].DeletedAt)
+
+	restored, err := mutation.RestoreComment(ctx, reply.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Reply", restored.Content)
+	comments, _ = query.GetComments(ctx, post.ID, nil, nil, nil)
+	assert.Len(t, comments, 2)
+
+	// Надгробию возвращается текст из последней ревизии
+	restored, err = mutation.RestoreComment(adminCtx, parent.ID)
+	assert.NoError(t, err)
+	assert.False(t, restored.Deleted)
+	assert.Equal(t, "Parent", restored.Content)
+	_, err = mutation.RestoreComment(ctx, parent.ID)
+	assert.ErrorIs(t, err, storage.ErrConflict)
+
+	_, _ = mutation.DeleteComment(ctx, earlier.ID)
+	_, err = mutation.DeletePost(ctx, post.ID)
+	assert.NoError(t, err)
+	_, err = query.GetPost(ctx, post.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = mutation.RestoreComment(ctx, reply.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	deletedPosts, err := query.DeletedPosts(adminCtx)
+	assert.NoError(t, err)
+	assert.Len(t, deletedPosts, 1)
+
+	otherCtx, _ := register(t, resolver, "otheruser")
+	_, err = mutation.RestorePost(otherCtx, post.ID)
+	assert.ErrorIs(t, err, storage.ErrForbidden)
+
+	restoredPost, err := mutation.RestorePost(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, restoredPost.DeletedAt)
+	comments, _ = query.GetComments(ctx, post.ID, nil, nil, nil)
+	assert.Len(t, comments, 1)
+	assert.Equal(t, parent.ID, comments[0].ID)
+	children, _ := (&commentResolver{resolver}).Children(ctx, comments[0], nil, nil, nil)
+	assert.Len(t, children, 1)
+
+	// Удалённый пользователь не может войти, но остаётся автором своих постов
+	author, _ := auth.UserFromContext(ctx)
+	authorID := strconv.FormatUint(uint64(author.ID), 10)
+	_, err = mutation.DeleteUser(ctx, authorID)
+	assert.ErrorIs(t, err, storage.ErrForbidden)
+	ok, err := mutation.DeleteUser(adminCtx, authorID)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	_, err = mutation.Login(context.Background(), "testuser", "password123")
+	assert.Error(t, err)
+	postAuthor, err := (&postResolver{resolver}).Author(ctx, restoredPost)
+	assert.NoError(t, err)
+	assert.Equal(t, "testuser", postAuthor.Username)
+
+	restoredUser, err := mutation.RestoreUser(adminCtx, authorID)
+	assert.NoError(t, err)
+	assert.Equal(t, "testuser", restoredUser.Username)
+	_, err = mutation.Login(context.Background(), "testuser", "password123")
+	assert.NoError(t, err)
+}
+
 func TestGetPosts(t *testing.T) {
 	db := setupTestDB(t)
 	resolver := &Resolver{
 		DB:    db,
 		Store: storage.NewMemoryStorage(),
+		Auth:  auth.NewManager("test-secret", time.Hour),
 	}
 	mutation := &mutationResolver{resolver}
 	query := &queryResolver{resolver}
-	ctx := context.Background()
 
-	user, _ := mutation.CreateUser(ctx, "testuser")
-	_, _ = mutation.CreatePost(ctx, "Post 1", "Content 1", user.ID)
-	_, _ = mutation.CreatePost(ctx, "Post 2", "Content 2", user.ID)
+	ctx, _ := register(t, resolver, "testuser")
+	_, _ = mutation.CreatePost(ctx, "Post 1", "Content 1", nil)
+	_, _ = mutation.CreatePost(ctx, "Post 2", "Content 2", nil)
 
-	posts, err := query.GetPosts(ctx)
+	posts, err := query.GetPosts(ctx, nil)
 	assert.NoError(t, err)
 	assert.Len(t, posts, 2)
 }
@@ -140,31 +715,31 @@ func TestGetPosts(t *testing.T) {
 func TestGetPostWithComments(t *testing.T) {
 	db := setupTestDB(t)
 	resolver := &Resolver{
-		DB:               db,
-		Store:            storage.NewMemoryStorage(),
-		CommentObservers: make(map[string][]chan *model.Comment),
+		DB:     db,
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
 	}
 	mutation := &mutationResolver{resolver}
 	query := &queryResolver{resolver}
-	ctx := context.Background()
 
-	user, _ := mutation.CreateUser(ctx, "testuser")
-	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", user.ID)
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
 
-	comment1, _ := mutation.CreateComment(ctx, post.ID, nil, user.ID, "Parent comment")
-	comment2, _ := mutation.CreateComment(ctx, post.ID, &comment1.ID, user.ID, "Child comment")
+	comment1, _ := mutation.CreateComment(ctx, post.ID, nil, "Parent comment")
+	comment2, _ := mutation.CreateComment(ctx, post.ID, &comment1.ID, "Child comment")
 
 	fetchedPost, err := query.GetPost(ctx, post.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, post.ID, fetchedPost.ID)
 
-	comments, err := query.GetComments(ctx, post.ID, nil, nil)
+	comments, err := query.GetComments(ctx, post.ID, nil, nil, nil)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, comments)
 
 	assert.Equal(t, comment1.Content, comments[0].Content)
 
-	children, err := (&commentResolver{resolver}).Children(ctx, comments[0])
+	children, err := (&commentResolver{resolver}).Children(ctx, comments[0], nil, nil, nil)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, children)
 	assert.Equal(t, comment2.Content, children[0].Content)
@@ -173,24 +748,638 @@ func TestGetPostWithComments(t *testing.T) {
 func TestPaginatedComments(t *testing.T) {
 	db := setupTestDB(t)
 	resolver := &Resolver{
-		DB:               db,
-		Store:            storage.NewMemoryStorage(),
-		CommentObservers: make(map[string][]chan *model.Comment),
+		DB:     db,
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
 	}
 	mutation := &mutationResolver{resolver}
 	query := &queryResolver{resolver}
-	ctx := context.Background()
 
-	user, _ := mutation.CreateUser(ctx, "testuser")
-	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", user.ID)
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
 
 	for i := 0; i < 15; i++ {
-		_, _ = mutation.CreateComment(ctx, post.ID, nil, user.ID, fmt.Sprintf("Comment %d", i))
+		_, _ = mutation.CreateComment(ctx, post.ID, nil, fmt.Sprintf("Comment %d", i))
 	}
 
 	limit := int32(5)
 	offset := int32(0)
-	comments, err := query.GetComments(ctx, post.ID, &limit, &offset)
+	comments, err := query.GetComments(ctx, post.ID, &limit, &offset, nil)
 	assert.NoError(t, err)
 	assert.Len(t, comments, 5)
 }
+
+func TestPostThread(t *testing.T) {
+	resolver := &Resolver{
+		DB:     setupTestDB(t),
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	postRes := &postResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+	first, _ := mutation.CreateComment(ctx, post.ID, nil, "First")
+	reply, _ := mutation.CreateComment(ctx, post.ID, &first.ID, "Reply")
+	second, _ := mutation.CreateComment(ctx, post.ID, nil, "Second")
+
+	thread, err := postRes.Thread(ctx, post, nil, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, thread, 3) {
+		assert.Equal(t, reply.ID, thread[1].Comment.ID)
+		assert.Equal(t, int32(1), thread[1].Depth)
+		assert.Equal(t, []string{first.ID, reply.ID}, thread[1].Path)
+		assert.Equal(t, second.ID, thread[2].Comment.ID)
+	}
+
+	depth := int32(1)
+	thread, err = postRes.Thread(ctx, post, nil, &depth)
+	assert.NoError(t, err)
+	assert.Len(t, thread, 2)
+
+	zero := int32(0)
+	_, err = postRes.Thread(ctx, post, &zero, nil)
+	assert.ErrorIs(t, err, storage.ErrValidation)
+}
+
+func TestCommentDepthAndAncestry(t *testing.T) {
+	resolver := &Resolver{
+		DB:     setupTestDB(t),
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	commentRes := &commentResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+	root, _ := mutation.CreateComment(ctx, post.ID, nil, "Root")
+	child, _ := mutation.CreateComment(ctx, post.ID, &root.ID, "Child")
+	grandchild, err := mutation.CreateComment(ctx, post.ID, &child.ID, "Grandchild")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), root.Depth)
+	assert.Equal(t, int32(2), grandchild.Depth)
+
+	ancestors, err := commentRes.Ancestors(ctx, grandchild)
+	assert.NoError(t, err)
+	if assert.Len(t, ancestors, 2) {
+		assert.Equal(t, root.ID, ancestors[0].ID)
+		assert.Equal(t, child.ID, ancestors[1].ID)
+	}
+
+	ancestors, err = commentRes.Ancestors(ctx, root)
+	assert.NoError(t, err)
+	assert.Empty(t, ancestors)
+
+	descendants, err := commentRes.Descendants(ctx, root, nil)
+	assert.NoError(t, err)
+	assert.Len(t, descendants, 2)
+
+	one := int32(1)
+	descendants, err = commentRes.Descendants(ctx, root, &one)
+	assert.NoError(t, err)
+	if assert.Len(t, descendants, 1) {
+		assert.Equal(t, child.ID, descendants[0].ID)
+	}
+}
+
+func TestMaxReplyDepth(t *testing.T) {
+	resolver := &Resolver{
+		DB:            setupTestDB(t),
+		Store:         storage.NewMemoryStorage(),
+		Auth:          auth.NewManager("test-secret", time.Hour),
+		PubSub:        pubsub.NewMemory(pubsub.Options{}),
+		MaxReplyDepth: 2,
+	}
+	mutation := &mutationResolver{resolver}
+	commentRes := &commentResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+	root, _ := mutation.CreateComment(ctx, post.ID, nil, "Root")
+	child, _ := mutation.CreateComment(ctx, post.ID, &root.ID, "Child")
+	grandchild, _ := mutation.CreateComment(ctx, post.ID, &child.ID, "Grandchild")
+	assert.Equal(t, int32(2), grandchild.Depth)
+
+	// Ответ на комментарий предельной глубины становится его соседом
+	deep, err := mutation.CreateComment(ctx, post.ID, &grandchild.ID, "Too deep")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), deep.Depth)
+	assert.Equal(t, child.ID, strconv.FormatUint(uint64(*deep.ParentID), 10))
+	replyTo, err := commentRes.ReplyTo(ctx, deep)
+	assert.NoError(t, err)
+	if assert.NotNil(t, replyTo) {
+		assert.Equal(t, grandchild.ID, replyTo.ID)
+	}
+
+	replyTo, err = commentRes.ReplyTo(ctx, grandchild)
+	assert.NoError(t, err)
+	assert.Nil(t, replyTo)
+
+	// Ограничение поста важнее ограничения сервиса
+	postID, _ := strconv.ParseUint(post.ID, 10, 64)
+	stored, err := resolver.Store.GetPost(ctx, uint(postID))
+	assert.NoError(t, err)
+	one := int32(1)
+	updated, err := mutation.SetMaxReplyDepth(ctx, post.ID, &one)
+	assert.NoError(t, err)
+	assert.Equal(t, &one, updated.MaxReplyDepth)
+	assert.Nil(t, stored.MaxReplyDepth, "прочитанный ранее пост не меняется на месте")
+	flat, err := mutation.CreateComment(ctx, post.ID, &grandchild.ID, "Flat")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), flat.Depth)
+	assert.Equal(t, root.ID, strconv.FormatUint(uint64(*flat.ParentID), 10))
+
+	updated, err = mutation.SetMaxReplyDepth(ctx, post.ID, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, updated.MaxReplyDepth)
+
+	zero := int32(0)
+	_, err = mutation.SetMaxReplyDepth(ctx, post.ID, &zero)
+	assert.ErrorIs(t, err, storage.ErrValidation)
+	_, err = mutation.CreatePost(ctx, "Other", "Content", &zero)
+	assert.ErrorIs(t, err, storage.ErrValidation)
+
+	otherCtx, _ := register(t, resolver, "otheruser")
+	_, err = mutation.SetMaxReplyDepth(otherCtx, post.ID, &one)
+	assert.ErrorIs(t, err, storage.ErrForbidden)
+}
+
+func TestRelationalFields(t *testing.T) {
+	db := setupTestDB(t)
+	resolver := &Resolver{
+		DB:     db,
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	postRes := &postResolver{resolver}
+	commentRes := &commentResolver{resolver}
+
+	ctx, user := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+	parent, _ := mutation.CreateComment(ctx, post.ID, nil, "Parent comment")
+	child, _ := mutation.CreateComment(ctx, post.ID, &parent.ID, "Child comment")
+
+	author, err := postRes.Author(ctx, post)
+	assert.NoError(t, err)
+	assert.Equal(t, user.ID, author.ID)
+
+	comments, err := postRes.Comments(ctx, post, nil, nil, nil)
+	assert.NoError(t, err)
+	assert.Len(t, comments, 1)
+	assert.Equal(t, parent.ID, comments[0].ID)
+
+	commentPost, err := commentRes.Post(ctx, child)
+	assert.NoError(t, err)
+	assert.Equal(t, post.ID, commentPost.ID)
+
+	commentAuthor, err := commentRes.Author(ctx, child)
+	assert.NoError(t, err)
+	assert.Equal(t, "testuser", commentAuthor.Username)
+
+	childParent, err := commentRes.Parent(ctx, child)
+	assert.NoError(t, err)
+	assert.Equal(t, parent.ID, childParent.ID)
+
+	rootParent, err := commentRes.Parent(ctx, parent)
+	assert.NoError(t, err)
+	assert.Nil(t, rootParent)
+}
+
+type countingStorage struct {
+	storage.Storage
+	mu           sync.Mutex
+	userBatch    int
+	commentBatch int
+	childBatch   int
+	countBatch   int
+}
+
+func (s *countingStorage) GetCommentsByIDs(ctx context.Context, ids []uint) ([]*models.Comment, error) {
+	s.mu.Lock()
+	s.commentBatch++
+	s.mu.Unlock()
+	return s.Storage.GetCommentsByIDs(ctx, ids)
+}
+
+func (s *countingStorage) GetUsersByIDs(ctx context.Context, ids []uint) ([]*models.User, error) {
+	s.mu.Lock()
+	s.userBatch++
+	s.mu.Unlock()
+	return s.Storage.GetUsersByIDs(ctx, ids)
+}
+
+func (s *countingStorage) GetChildrenByParentIDs(ctx context.Context, parentIDs []uint, order storage.SortOrder, limit int) (map[uint][]*models.Comment, error) {
+	s.mu.Lock()
+	s.childBatch++
+	s.mu.Unlock()
+	return s.Storage.GetChildrenByParentIDs(ctx, parentIDs, order, limit)
+}
+
+func (s *countingStorage) GetReplyCounts(ctx context.Context, ids []uint) (map[uint]storage.ReplyCounts, error) {
+	s.mu.Lock()
+	s.countBatch++
+	s.mu.Unlock()
+	return s.Storage.GetReplyCounts(ctx, ids)
+}
+
+// withLoaders возвращает контекст запроса, прошедшего через loaders.Middleware
+func withLoaders(t *testing.T, store storage.Storage) context.Context {
+	var ctx context.Context
+	handler := loaders.Middleware(store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/query", nil))
+	if loaders.For(ctx) == nil {
+		t.Fatal("loaders are not injected into the context")
+	}
+	return ctx
+}
+
+func TestLoadersBatchRequests(t *testing.T) {
+	store := &countingStorage{Storage: storage.NewMemoryStorage()}
+	resolver := &Resolver{
+		Store:  store,
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	commentRes := &commentResolver{resolver}
+
+	authCtx, user := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(authCtx, "Test Post", "Content", nil)
+
+	var comments []*model.Comment
+	for i := 0; i < 10; i++ {
+		comment, err := mutation.CreateComment(authCtx, post.ID, nil, fmt.Sprintf("Comment %d", i))
+		assert.NoError(t, err)
+		comments = append(comments, comment)
+	}
+
+	ctx := withLoaders(t, store)
+	var wg sync.WaitGroup
+	for _, comment := range comments {
+		wg.Add(1)
+		go func(comment *model.Comment) {
+			defer wg.Done()
+			author, err := commentRes.Author(ctx, comment)
+			assert.NoError(t, err)
+			assert.Equal(t, user.ID, author.ID)
+
+			children, err := commentRes.Children(ctx, comment, nil, nil, nil)
+			assert.NoError(t, err)
+			assert.Empty(t, children)
+
+			count, err := commentRes.ReplyCount(ctx, comment)
+			assert.NoError(t, err)
+			assert.Zero(t, count)
+		}(comment)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, store.userBatch)
+	assert.Equal(t, 1, store.childBatch)
+	assert.Equal(t, 1, store.countBatch)
+
+	// Предки всех комментариев ветки загружаются одной пачкой
+	thread := []*model.Comment{comments[0]}
+	for i := 0; i < 3; i++ {
+		reply, err := mutation.CreateComment(authCtx, post.ID, &thread[len(thread)-1].ID, fmt.Sprintf("Reply %d", i))
+		assert.NoError(t, err)
+		thread = append(thread, reply)
+	}
+	ctx = withLoaders(t, store)
+	for _, comment := range thread[1:] {
+		wg.Add(1)
+		go func(comment *model.Comment) {
+			defer wg.Done()
+			ancestors, err := commentRes.Ancestors(ctx, comment)
+			assert.NoError(t, err)
+			assert.Equal(t, thread[0].ID, ancestors[0].ID)
+		}(comment)
+	}
+	wg.Wait()
+	assert.Equal(t, 1, store.commentBatch)
+
+	// Без middleware пачку собирать некому: каждый ключ загружается сразу
+	for _, comment := range comments[:3] {
+		author, err := commentRes.Author(context.Background(), comment)
+		assert.NoError(t, err)
+		assert.Equal(t, user.ID, author.ID)
+	}
+	assert.Equal(t, 4, store.userBatch)
+}
+
+func TestReplyCountsAndMoreReplies(t *testing.T) {
+	resolver := &Resolver{
+		DB:     setupTestDB(t),
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	commentRes := &commentResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+	root, _ := mutation.CreateComment(ctx, post.ID, nil, "Root")
+	var replies []*model.Comment
+	for i := 0; i < 5; i++ {
+		reply, err := mutation.CreateComment(ctx, post.ID, &root.ID, fmt.Sprintf("Reply %d", i))
+		assert.NoError(t, err)
+		replies = append(replies, reply)
+	}
+	_, err := mutation.CreateComment(ctx, post.ID, &replies[0].ID, "Nested")
+	assert.NoError(t, err)
+
+	count, err := commentRes.ReplyCount(ctx, root)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(5), count)
+	count, err = commentRes.DescendantCount(ctx, root)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(6), count)
+
+	two := int32(2)
+	page, err := commentRes.Children(ctx, root, &two, nil, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 2) {
+		assert.Equal(t, replies[1].ID, page[1].ID)
+	}
+
+	page, err = commentRes.Children(ctx, root, &two, &page[1].ID, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, page, 2) {
+		assert.Equal(t, replies[2].ID, page[0].ID)
+	}
+
+	_, err = commentRes.Children(ctx, root, nil, &root.ID, nil)
+	assert.ErrorIs(t, err, storage.ErrValidation)
+	assert.Equal(t, "after", ErrorPresenter(ctx, err).Extensions["field"])
+
+	// Без first отдаётся страница по умолчанию, а не все ответы
+	for i := len(replies); i <= defaultPageSize; i++ {
+		_, err := mutation.CreateComment(ctx, post.ID, &root.ID, fmt.Sprintf("Reply %d", i))
+		assert.NoError(t, err)
+	}
+	page, err = commentRes.Children(ctx, root, nil, nil, nil)
+	assert.NoError(t, err)
+	if assert.Len(t, page, defaultPageSize) {
+		assert.Equal(t, replies[0].ID, page[0].ID)
+	}
+}
+
+func TestPostStats(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	query := &queryResolver{resolver}
+
+	authorCtx, _ := register(t, resolver, "author")
+	readerCtx, _ := register(t, resolver, "reader")
+	chatty, _ := mutation.CreatePost(authorCtx, "Chatty", "Content", nil)
+	lively, _ := mutation.CreatePost(authorCtx, "Lively", "Content", nil)
+	assert.Equal(t, int32(0), chatty.CommentCount)
+	assert.Nil(t, chatty.LastCommentAt)
+
+	for i := 0; i < 3; i++ {
+		_, err := mutation.CreateComment(authorCtx, chatty.ID, nil, fmt.Sprintf("Comment %d", i))
+		assert.NoError(t, err)
+	}
+	root, _ := mutation.CreateComment(authorCtx, lively.ID, nil, "Root")
+	reply, err := mutation.CreateComment(readerCtx, lively.ID, &root.ID, "Reply")
+	assert.NoError(t, err)
+
+	post, err := query.GetPost(authorCtx, lively.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), post.CommentCount)
+	assert.Equal(t, int32(2), post.ParticipantCount)
+	if assert.NotNil(t, post.LastCommentAt) {
+		assert.Equal(t, reply.CreatedAt, *post.LastCommentAt)
+	}
+
+	titles := func(order model.SortOrder) []string {
+		posts, err := query.GetPosts(authorCtx, &order)
+		assert.NoError(t, err)
+		result := make([]string, len(posts))
+		for i, post := range posts {
+			result[i] = post.Title
+		}
+		return result
+	}
+	assert.Equal(t, []string{"Chatty", "Lively"}, titles(model.SortOrderMostReplies))
+	assert.Equal(t, []string{"Lively", "Chatty"}, titles(model.SortOrderMostParticipants))
+	assert.Equal(t, []string{"Lively", "Chatty"}, titles(model.SortOrderRecentlyActive))
+
+	_, err = mutation.DeleteComment(readerCtx, reply.ID)
+	assert.NoError(t, err)
+	post, err = query.GetPost(authorCtx, lively.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), post.CommentCount)
+	assert.Equal(t, int32(1), post.ParticipantCount)
+	if assert.NotNil(t, post.LastCommentAt) {
+		assert.Equal(t, root.CreatedAt, *post.LastCommentAt)
+	}
+}
+
+func TestCursorPagination(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	query := &queryResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+	for i := 0; i < 12; i++ {
+		_, _ = mutation.CreateComment(ctx, post.ID, nil, fmt.Sprintf("Comment %d", i))
+	}
+
+	first := int32(5)
+	var after *string
+	var seen []string
+	for page := 0; ; page++ {
+		conn, err := query.Comments(ctx, post.ID, &first, after, nil)
+		assert.NoError(t, err)
+		for _, edge := range conn.Edges {
+			seen = append(seen, edge.Node.Content)
+		}
+		if page == 0 {
+			// Новый комментарий во время прокрутки попадает в конец, не сдвигая страницы
+			_, _ = mutation.CreateComment(ctx, post.ID, nil, "Late comment")
+		}
+		if !conn.PageInfo.HasNextPage {
+			break
+		}
+		after = conn.PageInfo.EndCursor
+	}
+
+	assert.Len(t, seen, 13)
+	assert.Equal(t, "Comment 0", seen[0])
+	assert.Equal(t, "Late comment", seen[12])
+
+	bad := "not-a-cursor"
+	_, err := query.Comments(ctx, post.ID, &first, &bad, nil)
+	assert.Error(t, err)
+}
+
+func TestSortOrders(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	query := &queryResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	quiet, _ := mutation.CreatePost(ctx, "Quiet", "Content", nil)
+	busy, _ := mutation.CreatePost(ctx, "Busy", "Content", nil)
+	fresh, _ := mutation.CreatePost(ctx, "Fresh", "Content", nil)
+
+	first, _ := mutation.CreateComment(ctx, busy.ID, nil, "First")
+	second, _ := mutation.CreateComment(ctx, busy.ID, nil, "Second")
+	_, _ = mutation.CreateComment(ctx, busy.ID, &second.ID, "Reply")
+	_, _ = mutation.CreateComment(ctx, quiet.ID, nil, "Latest")
+
+	titles := func(order model.SortOrder) []string {
+		posts, err := query.GetPosts(ctx, &order)
+		assert.NoError(t, err)
+		result := make([]string, len(posts))
+		for i, post := range posts {
+			result[i] = post.Title
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"Fresh", "Busy", "Quiet"}, titles(model.SortOrderNewest))
+	assert.Equal(t, []string{"Quiet", "Busy", "Fresh"}, titles(model.SortOrderOldest))
+	assert.Equal(t, []string{"Busy", "Quiet", "Fresh"}, titles(model.SortOrderMostReplies))
+	assert.Equal(t, []string{"Quiet", "Busy", "Fresh"}, titles(model.SortOrderRecentlyActive))
+
+	defaultPosts, err := query.GetPosts(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, fresh.ID, defaultPosts[0].ID)
+
+	mostReplies := model.SortOrderMostReplies
+	comments, err := query.GetComments(ctx, busy.ID, nil, nil, &mostReplies)
+	assert.NoError(t, err)
+	assert.Equal(t, second.ID, comments[0].ID)
+	assert.Equal(t, first.ID, comments[1].ID)
+
+	// Курсор, выданный для одного порядка, нельзя использовать с другим
+	size := int32(1)
+	conn, err := query.Comments(ctx, busy.ID, &size, nil, &mostReplies)
+	assert.NoError(t, err)
+	_, err = query.Comments(ctx, busy.ID, &size, conn.PageInfo.EndCursor, nil)
+	assert.Error(t, err)
+}
+
+func TestErrorCodes(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	query := &queryResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	otherCtx, _ := register(t, resolver, "otheruser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+
+	code := func(err error) interface{} {
+		return ErrorPresenter(context.Background(), err).Extensions["code"]
+	}
+
+	_, err := query.GetPost(ctx, "100500")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.Equal(t, "NOT_FOUND", code(err))
+
+	_, err = mutation.ToggleComments(otherCtx, post.ID, true)
+	assert.ErrorIs(t, err, storage.ErrForbidden)
+	assert.Equal(t, "FORBIDDEN", code(err))
+
+	_, err = mutation.Register(context.Background(), "", "password123")
+	assert.Equal(t, "BAD_USER_INPUT", code(err))
+
+	_, err = mutation.Register(context.Background(), "testuser", "password123")
+	assert.ErrorIs(t, err, storage.ErrConflict)
+	assert.Equal(t, "CONFLICT", code(err))
+
+	_, err = mutation.CreatePost(context.Background(), "Title", "Content", nil)
+	assert.Equal(t, "UNAUTHENTICATED", code(err))
+}
+
+func TestInputValidation(t *testing.T) {
+	resolver := &Resolver{
+		Store:  storage.NewMemoryStorage(),
+		Auth:   auth.NewManager("test-secret", time.Hour),
+		PubSub: pubsub.NewMemory(pubsub.Options{}),
+	}
+	mutation := &mutationResolver{resolver}
+	query := &queryResolver{resolver}
+
+	ctx, _ := register(t, resolver, "testuser")
+	post, _ := mutation.CreatePost(ctx, "Test Post", "Content", nil)
+
+	field := func(err error) interface{} {
+		assert.ErrorIs(t, err, storage.ErrValidation)
+		return ErrorPresenter(context.Background(), err).Extensions["field"]
+	}
+
+	_, err := mutation.CreateComment(ctx, "abc", nil, "Comment")
+	assert.Equal(t, "postID", field(err))
+
+	badParent := "-1"
+	_, err = mutation.CreateComment(ctx, post.ID, &badParent, "Comment")
+	assert.Equal(t, "parentID", field(err))
+
+	_, err = mutation.CreateComment(ctx, post.ID, nil, "   ")
+	assert.Equal(t, "content", field(err))
+
+	_, err = mutation.CreateComment(ctx, post.ID, nil, strings.Repeat("я", maxCommentLength+1))
+	assert.Equal(t, "content", field(err))
+
+	comment, err := mutation.CreateComment(ctx, post.ID, nil, strings.Repeat("я", maxCommentLength))
+	assert.NoError(t, err)
+	assert.NotNil(t, comment)
+
+	_, err = mutation.CreatePost(ctx, "", "Content", nil)
+	assert.Equal(t, "title", field(err))
+	assert.EqualError(t, err, "заголовок не может быть пустым")
+
+	_, err = mutation.CreatePost(ctx, strings.Repeat("t", maxTitleLength+1), "Content", nil)
+	assert.Equal(t, "title", field(err))
+
+	_, err = mutation.ToggleComments(ctx, "0", true)
+	assert.Equal(t, "postID", field(err))
+
+	_, err = query.GetPost(ctx, "1.5")
+	assert.Equal(t, "id", field(err))
+	assert.EqualError(t, err, "некорректный идентификатор")
+
+	limit := int32(-1)
+	_, err = query.GetComments(ctx, post.ID, &limit, nil, nil)
+	assert.Equal(t, "limit", field(err))
+
+	// 36 кириллических символов — 72 байта, на один больше уже нельзя
+	_, err = mutation.Register(context.Background(), "longpassword", strings.Repeat("я", maxPasswordBytes/2+1))
+	assert.Equal(t, "password", field(err))
+	_, err = mutation.Register(context.Background(), "longpassword", strings.Repeat("я", maxPasswordBytes/2))
+	assert.NoError(t, err)
+
+	posts, err := query.GetPosts(ctx, nil)
+	assert.NoError(t, err)
+	assert.Len(t, posts, 1)
+}
@@ -0,0 +1,76 @@
+package graph
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+// errSubscriptionOverflow — подписка закрыта сервером: клиент не успевал читать события
+var errSubscriptionOverflow = errors.New("подписка закрыта: клиент не успевает получать события")
+
+type subscriptionEndKey struct{}
+
+// subscriptionEnd хранит причину, по которой резолвер завершил подписку
+type subscriptionEnd struct {
+	mu  sync.Mutex
+	err error
+}
+
+func (e *subscriptionEnd) take() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	err := e.err
+	e.err = nil
+	return err
+}
+
+// endSubscription запоминает ошибку, с которой закрывается подписка. Вызывается
+// до закрытия канала резолвера, иначе клиент увидит обычный конец потока.
+func endSubscription(ctx context.Context, err error) {
+	if end, ok := ctx.Value(subscriptionEndKey{}).(*subscriptionEnd); ok {
+		end.mu.Lock()
+		end.err = err
+		end.mu.Unlock()
+	}
+}
+
+// SubscriptionErrors — расширение сервера: если резолвер закрыл подписку
+// с ошибкой, клиент получает её последним сообщением потока.
+type SubscriptionErrors struct{}
+
+var (
+	_ graphql.HandlerExtension     = SubscriptionErrors{}
+	_ graphql.OperationInterceptor = SubscriptionErrors{}
+	_ graphql.ResponseInterceptor  = SubscriptionErrors{}
+)
+
+func (SubscriptionErrors) ExtensionName() string {
+	return "SubscriptionErrors"
+}
+
+func (SubscriptionErrors) Validate(graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (SubscriptionErrors) InterceptOperation(ctx context.Context, next graphql.OperationHandler) graphql.ResponseHandler {
+	return next(context.WithValue(ctx, subscriptionEndKey{}, &subscriptionEnd{}))
+}
+
+func (SubscriptionErrors) InterceptResponse(ctx context.Context, next graphql.ResponseHandler) *graphql.Response {
+	resp := next(ctx)
+	if resp != nil {
+		return resp
+	}
+	end, ok := ctx.Value(subscriptionEndKey{}).(*subscriptionEnd)
+	if !ok {
+		return nil
+	}
+	if err := end.take(); err != nil {
+		graphql.AddError(ctx, err)
+		return &graphql.Response{Errors: graphql.GetErrors(ctx)}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package graph
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Anabol1ks/ozon_tz/pkg/storage"
+)
+
+const (
+	maxUsernameLength = 50
+	maxTitleLength    = 200
+	maxCommentLength  = 2000
+)
+
+// ValidationError описывает некорректное значение конкретного аргумента.
+// Имя аргумента попадает в extensions.field ответа, а сообщение для
+// пользователя называет его по-русски.
+type ValidationError struct {
+	Field   string
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+func (e *ValidationError) Unwrap() error {
+	return storage.ErrValidation
+}
+
+func invalid(field, message string) error {
+	return &ValidationError{Field: field, Message: message}
+}
+
+// fieldNames — названия аргументов в сообщениях общих проверок
+var fieldNames = map[string]string{
+	"id":             "идентификатор",
+	"postID":         "идентификатор поста",
+	"parentID":       "идентификатор родительского комментария",
+	"revisionID":     "идентификатор версии",
+	"afterCommentID": "идентификатор последнего полученного комментария",
+	"after":          "идентификатор последнего показанного ответа",
+	"title":          "заголовок",
+	"content":        "текст",
+	"username":       "имя пользователя",
+	"limit":          "лимит",
+	"rootLimit":      "лимит корневых комментариев",
+	"maxDepth":       "лимит глубины",
+	"maxReplyDepth":  "лимит глубины ответов",
+}
+
+func fieldName(field string) string {
+	if name, ok := fieldNames[field]; ok {
+		return name
+	}
+	return field
+}
+
+// parseID разбирает идентификатор из аргумента GraphQL. В отличие от
+// strconv.ParseUint с отброшенной ошибкой, "abc" не превращается в 0.
+func parseID(field, value string) (uint, error) {
+	id, err := strconv.ParseUint(value, 10, 64)
+	if err != nil || id == 0 {
+		return 0, invalid(field, "некорректный "+fieldName(field))
+	}
+	return uint(id), nil
+}
+
+func parseOptionalID(field string, value *string) (*uint, error) {
+	if value == nil {
+		return nil, nil
+	}
+	id, err := parseID(field, *value)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+func validateText(field, value string, maxLength int) error {
+	if strings.TrimSpace(value) == "" {
+		return invalid(field, fieldName(field)+" не может быть пустым")
+	}
+	if maxLength > 0 && utf8.RuneCountInString(value) > maxLength {
+		return invalid(field, fmt.Sprintf("%s не может быть длиннее %d символов", fieldName(field), maxLength))
+	}
+	return nil
+}
+
+func validateUsername(username string) error {
+	return validateText("username", username, maxUsernameLength)
+}
+
+func validatePassword(password string) error {
+	if utf8.RuneCountInString(password) < minPasswordLength {
+		return invalid("password", fmt.Sprintf("пароль должен быть не короче %d символов", minPasswordLength))
+	}
+	if len(password) > maxPasswordBytes {
+		return invalid("password", fmt.Sprintf("пароль не может быть длиннее %d байт", maxPasswordBytes))
+	}
+	return nil
+}
+
+func validatePost(title, content string) error {
+	if err := validateText("title", title, maxTitleLength); err != nil {
+		return err
+	}
+	return validateText("content", content, 0)
+}
+
+func validateComment(content string) error {
+	return validateText("content", content, maxCommentLength)
+}
+
+func validateLimitOffset(limit, offset *int32) error {
+	if limit != nil && *limit < 0 {
+		return invalid("limit", "лимит не может быть отрицательным")
+	}
+	if offset != nil && *offset < 0 {
+		return invalid("offset", "смещение не может быть отрицательным")
+	}
+	return nil
+}
+
+// positiveLimit разбирает необязательное ограничение; 0 означает «без ограничения».
+func positiveLimit(field string, value *int32) (int, error) {
+	if value == nil {
+		return 0, nil
+	}
+	if *value < 1 {
+		return 0, invalid(field, fieldName(field)+" должен быть положительным")
+	}
+	return int(*value), nil
+}
+
+func parseMaxReplyDepth(field string, value *int32) (*int, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if *value < 1 {
+		return nil, invalid(field, fieldName(field)+" должен быть положительным")
+	}
+	depth := int(*value)
+	return &depth, nil
+}
@@ -2,14 +2,27 @@ package models
 
 import (
 	"time"
+
+	"gorm.io/gorm"
 )
 
+// Comment с Deleted — надгробие: комментарий удалён, но остался в ветке ради ответов.
+// Мягко удалённые записи, скрытые из выборок, отличаются заполненным DeletedAt.
+// Path — id предков от корня через "/" (у корневых пустой), Depth — их число;
+// оба заполняются хранилищем при создании. ReplyToID задан, если ответ на
+// слишком глубокий комментарий прикреплён к его предку, и указывает на исходный.
 type Comment struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	PostID    uint      `gorm:"not null" json:"post_id"`
-	AuthorID  uint      `gorm:"not null" json:"author_id"`
-	ParentID  *uint     `json:"parent_id"`
-	Content   string    `gorm:"not null;size:2000" json:"content"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID        uint           `gorm:"primaryKey" json:"id"`
+	PostID    uint           `gorm:"not null" json:"post_id"`
+	AuthorID  uint           `gorm:"not null" json:"author_id"`
+	ParentID  *uint          `json:"parent_id"`
+	Path      string         `gorm:"not null;default:''" json:"path"`
+	Depth     int            `gorm:"not null;default:0" json:"depth"`
+	ReplyToID *uint          `json:"reply_to_id"`
+	Content   string         `gorm:"not null;size:2000" json:"content"`
+	Deleted   bool           `gorm:"not null;default:false" json:"deleted"`
+	EditedAt  *time.Time     `json:"edited_at"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+	DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 }
@@ -1,13 +1,26 @@
 package models
 
-import "time"
+import (
+	"time"
 
+	"gorm.io/gorm"
+)
+
+// MaxReplyDepth переопределяет для поста допустимую глубину ответов,
+// nil — действует ограничение всего сервиса. CommentCount, ParticipantCount
+// и LastCommentAt описывают видимые комментарии поста (без надгробий)
+// и пересчитываются хранилищем.
 type Post struct {
-	ID              uint      `gorm:"primaryKey" json:"id"`
-	Title           string    `gorm:"not null" json:"title"`
-	Content         string    `gorm:"not null" json:"content"`
-	AuthorID        uint      `gorm:"not null" json:"author_id"`
-	DisableComments bool      `gorm:"default:false" json:"disable_comments"`
-	CreatedAt       time.Time `json:"created_at"`
-	UpdatedAt       time.Time `json:"updated_at"`
+	ID               uint           `gorm:"primaryKey" json:"id"`
+	Title            string         `gorm:"not null" json:"title"`
+	Content          string         `gorm:"not null" json:"content"`
+	AuthorID         uint           `gorm:"not null" json:"author_id"`
+	DisableComments  bool           `gorm:"default:false" json:"disable_comments"`
+	MaxReplyDepth    *int           `json:"max_reply_depth"`
+	CommentCount     int            `gorm:"not null;default:0" json:"comment_count"`
+	ParticipantCount int            `gorm:"not null;default:0" json:"participant_count"`
+	LastCommentAt    *time.Time     `json:"last_comment_at"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 }
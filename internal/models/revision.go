@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+const (
+	RevisionPost    = "post"
+	RevisionComment = "comment"
+)
+
+// Revision — предыдущая версия поста или комментария, сохраняемая при каждом изменении текста
+type Revision struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	EntityType string    `gorm:"not null;size:16;index:idx_revisions_entity" json:"entity_type"`
+	EntityID   uint      `gorm:"not null;index:idx_revisions_entity" json:"entity_id"`
+	AuthorID   uint      `gorm:"not null" json:"author_id"`
+	Title      string    `json:"title"`
+	Content    string    `gorm:"not null" json:"content"`
+	CreatedAt  time.Time `json:"created_at"`
+}
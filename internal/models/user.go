@@ -1,9 +1,16 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type User struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	Username  string    `gorm:"not null;unique" json:"username"`
-	CreatedAt time.Time `json:"created_at"`
+	ID           uint           `gorm:"primaryKey" json:"id"`
+	Username     string         `gorm:"not null;unique" json:"username"`
+	PasswordHash string         `gorm:"not null;default:''" json:"-"`
+	IsAdmin      bool           `gorm:"not null;default:false" json:"is_admin"`
+	CreatedAt    time.Time      `json:"created_at"`
+	DeletedAt    gorm.DeletedAt `gorm:"index" json:"deleted_at"`
 }
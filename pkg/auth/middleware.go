@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/Anabol1ks/ozon_tz/pkg/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Middleware проверяет заголовок "Authorization: Bearer <token>" и кладёт
+// пользователя в контекст запроса. Запросы без заголовка проходят анонимно,
+// с невалидным токеном — отклоняются.
+func Middleware(m *Manager, store storage.Storage) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			c.Next()
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "ожидается Bearer токен"})
+			return
+		}
+
+		userID, err := m.ParseToken(token)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "невалидный токен"})
+			return
+		}
+
+		// Сбой базы не значит, что токен плохой: клиент не должен из-за него разлогиниваться
+		user, err := store.GetUser(c.Request.Context(), userID)
+		if errors.Is(err, storage.ErrNotFound) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "пользователь не найден"})
+			return
+		}
+		if err != nil {
+			log.Printf("Ошибка загрузки пользователя %d: %v", userID, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "не удалось проверить пользователя"})
+			return
+		}
+
+		c.Request = c.Request.WithContext(WithUser(c.Request.Context(), user))
+		c.Next()
+	}
+}
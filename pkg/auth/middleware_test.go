@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"github.com/Anabol1ks/ozon_tz/pkg/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type failingStorage struct {
+	storage.Storage
+}
+
+func (failingStorage) GetUser(ctx context.Context, id uint) (*models.User, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := NewManager("test-secret", time.Hour)
+	store := storage.NewMemoryStorage()
+	user := &models.User{Username: "alice", PasswordHash: "hash"}
+	require.NoError(t, store.CreateUser(context.Background(), user))
+
+	serve := func(store storage.Storage, header string) (int, *models.User) {
+		var seen *models.User
+		r := gin.New()
+		r.Use(Middleware(m, store))
+		r.GET("/", func(c *gin.Context) {
+			seen, _ = UserFromContext(c.Request.Context())
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code, seen
+	}
+
+	token, err := m.IssueToken(user.ID)
+	require.NoError(t, err)
+	code, seen := serve(store, "Bearer "+token)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.NotNil(t, seen) {
+		assert.Equal(t, user.ID, seen.ID)
+	}
+
+	code, seen = serve(store, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Nil(t, seen)
+
+	code, _ = serve(store, "Bearer garbage")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	missing, err := m.IssueToken(user.ID + 1000)
+	require.NoError(t, err)
+	code, _ = serve(store, "Bearer "+missing)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// Сбой хранилища — ошибка сервера, а не повод разлогинить клиента
+	code, _ = serve(failingStorage{store}, "Bearer "+token)
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
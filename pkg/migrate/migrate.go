@@ -0,0 +1,320 @@
+// Package migrate применяет версионированные SQL-миграции, встроенные в бинарник.
+// Файлы лежат в каталоге диалекта и называются NNNN_name.up.sql / NNNN_name.down.sql,
+// применённые версии записываются в таблицу schema_migrations.
+//
+// Файл, первая строка которого — "-- migrate:no-transaction", выполняется вне
+// транзакции, по одной инструкции: так работает CREATE INDEX CONCURRENTLY.
+// Инструкции такого файла должны быть идемпотентны (IF NOT EXISTS), чтобы
+// прерванную миграцию можно было просто запустить ещё раз.
+package migrate
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed postgres/*.sql sqlite/*.sql
+var embedded embed.FS
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+const noTransaction = "-- migrate:no-transaction"
+
+// statementEnd — конец инструкции: точка с запятой в конце строки
+var statementEnd = regexp.MustCompile(`;[ \t]*(\n|$)`)
+
+// lockKey — ключ advisory-блокировки PostgreSQL, под которой выполняются up и down:
+// реплики, одновременно запустившие migrate up, применяют миграции по очереди.
+const lockKey = 20240615
+
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+// Status — миграция и время её применения (nil, если ещё не применена).
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type schemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string { return "schema_migrations" }
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New выбирает набор миграций по диалекту подключения.
+func New(db *gorm.DB) (*Migrator, error) {
+	dialect := db.Dialector.Name()
+	sub, err := fs.Sub(embedded, dialect)
+	if err != nil {
+		return nil, err
+	}
+	m, err := newMigrator(db, sub)
+	if err != nil {
+		return nil, fmt.Errorf("migrations for %s: %w", dialect, err)
+	}
+	return m, nil
+}
+
+func newMigrator(db *gorm.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad migration version %q", entry.Name())
+		}
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.up = string(body)
+		} else {
+			m.down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no migrations found")
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up применяет все ещё не применённые миграции по возрастанию версии,
+// каждую в своей транзакции. Возвращает применённые миграции.
+func (m *Migrator) Up() ([]Migration, error) {
+	var done []Migration
+	err := m.locked(func(db *gorm.DB) error {
+		applied, err := applied(db)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			err := run(db, migration.up, func(tx *gorm.DB) error {
+				return tx.Create(&schemaMigration{
+					Version:   migration.Version,
+					Name:      migration.Name,
+					AppliedAt: time.Now(),
+				}).Error
+			})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+			}
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down откатывает последнюю применённую миграцию. Если применённых нет, возвращает nil.
+func (m *Migrator) Down() (*Migration, error) {
+	var migration *Migration
+	err := m.locked(func(db *gorm.DB) error {
+		var err error
+		migration, err = m.down(db)
+		return err
+	})
+	return migration, err
+}
+
+func (m *Migrator) down(db *gorm.DB) (*Migration, error) {
+	if err := ensureTable(db); err != nil {
+		return nil, err
+	}
+
+	var last schemaMigration
+	res := db.Order("version DESC").Limit(1).Find(&last)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	var migration *Migration
+	for i := range m.migrations {
+		if m.migrations[i].Version == last.Version {
+			migration = &m.migrations[i]
+			break
+		}
+	}
+	if migration == nil {
+		return nil, fmt.Errorf("applied migration %d_%s is unknown to this binary", last.Version, last.Name)
+	}
+
+	err := run(db, migration.down, func(tx *gorm.DB) error {
+		return tx.Delete(&schemaMigration{}, "version = ?", migration.Version).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+	}
+	return migration, nil
+}
+
+func (m *Migrator) Status() ([]Status, error) {
+	applied, err := applied(m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i].Migration = migration
+		if row, ok := applied[migration.Version]; ok {
+			appliedAt := row.AppliedAt
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+	return statuses, nil
+}
+
+// Pending возвращает миграции, которые ещё не применены.
+func (m *Migrator) Pending() ([]Migration, error) {
+	statuses, err := m.Status()
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, status.Migration)
+		}
+	}
+	return pending, nil
+}
+
+// locked выполняет fn на одном соединении под advisory-блокировкой. У SQLite
+// одна база на процесс, блокировка ему не нужна.
+func (m *Migrator) locked(fn func(db *gorm.DB) error) error {
+	if m.db.Dialector.Name() != "postgres" {
+		return fn(m.db)
+	}
+	return m.db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("SELECT pg_advisory_lock(?)", lockKey).Error; err != nil {
+			return err
+		}
+		defer conn.Exec("SELECT pg_advisory_unlock(?)", lockKey)
+		return fn(conn)
+	})
+}
+
+// run выполняет тело миграции и record, отмечающий её в schema_migrations:
+// обычно одной транзакцией, для файлов с noTransaction — по инструкциям.
+func run(db *gorm.DB, body string, record func(tx *gorm.DB) error) error {
+	if !strings.HasPrefix(body, noTransaction) {
+		return db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(body).Error; err != nil {
+				return err
+			}
+			return record(tx)
+		})
+	}
+
+	for _, statement := range statements(body) {
+		if err := db.Exec(statement).Error; err != nil {
+			return err
+		}
+	}
+	return record(db)
+}
+
+// statements делит тело миграции на инструкции. Части из одних комментариев пропускаются.
+func statements(body string) []string {
+	var result []string
+	for _, part := range statementEnd.Split(body, -1) {
+		code := false
+		for _, line := range strings.Split(part, "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && !strings.HasPrefix(line, "--") {
+				code = true
+				break
+			}
+		}
+		if code {
+			result = append(result, strings.TrimSpace(part))
+		}
+	}
+	return result
+}
+
+func ensureTable(db *gorm.DB) error {
+	return db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    BIGINT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`).Error
+}
+
+func applied(db *gorm.DB) (map[int64]schemaMigration, error) {
+	if err := ensureTable(db); err != nil {
+		return nil, err
+	}
+
+	var rows []schemaMigration
+	if err := db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int64]schemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
@@ -0,0 +1,299 @@
+package migrate
+
+import (
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestEmbeddedVersions(t *testing.T) {
+	for _, dialect := range []string{"postgres", "sqlite"} {
+		sub, err := fs.Sub(embedded, dialect)
+		require.NoError(t, err)
+		migrations, err := load(sub)
+		require.NoError(t, err, dialect)
+		for i, m := range migrations {
+			assert.Equal(t, int64(i+1), m.Version, "версии %s должны идти подряд", dialect)
+		}
+	}
+}
+
+func TestEmbeddedSQLite(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	m, err := New(db)
+	require.NoError(t, err)
+
+	_, err = m.Up()
+	require.NoError(t, err)
+	for _, table := range []string{"users", "posts", "comments", "revisions"} {
+		assert.True(t, db.Migrator().HasTable(table), table)
+	}
+	assert.True(t, db.Migrator().HasIndex("comments", "idx_comments_post_id_created_at"))
+	assert.True(t, db.Migrator().HasIndex("comments", "idx_comments_post_id_path"))
+
+	// Пути уже существующих комментариев и статистика постов заполняются при миграции
+	for {
+		rolledBack, err := m.Down()
+		require.NoError(t, err)
+		if rolledBack.Version == 3 {
+			break
+		}
+	}
+	require.NoError(t, db.Exec(`INSERT INTO posts (id, title, content, author_id) VALUES (1, 'p', 't', 1)`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO comments (id, post_id, author_id, parent_id, content) VALUES
+		(1, 1, 1, NULL, 'a'), (2, 1, 1, 1, 'b'), (3, 1, 2, 2, 'c')`).Error)
+	_, err = m.Up()
+	require.NoError(t, err)
+	var paths []struct {
+		Path  string
+		Depth int
+	}
+	require.NoError(t, db.Raw("SELECT path, depth FROM comments ORDER BY id").Scan(&paths).Error)
+	require.Len(t, paths, 3)
+	assert.Equal(t, "", paths[0].Path)
+	assert.Equal(t, "1/2/", paths[2].Path)
+	assert.Equal(t, 2, paths[2].Depth)
+	var stats struct {
+		CommentCount     int
+		ParticipantCount int
+	}
+	require.NoError(t, db.Raw("SELECT comment_count, participant_count FROM posts WHERE id = 1").Scan(&stats).Error)
+	assert.Equal(t, 3, stats.CommentCount)
+	assert.Equal(t, 2, stats.ParticipantCount)
+
+	for {
+		rolledBack, err := m.Down()
+		require.NoError(t, err)
+		if rolledBack == nil {
+			break
+		}
+	}
+	assert.False(t, db.Migrator().HasTable("users"))
+}
+
+func TestUpDownStatus(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	m, err := newMigrator(db, fstest.MapFS{
+		"0001_items.up.sql":   {Data: []byte("CREATE TABLE items (id INTEGER PRIMARY KEY);")},
+		"0001_items.down.sql": {Data: []byte("DROP TABLE items;")},
+		"0002_tags.up.sql":    {Data: []byte("CREATE TABLE tags (id INTEGER PRIMARY KEY); CREATE INDEX idx_tags ON tags (id);")},
+		"0002_tags.down.sql":  {Data: []byte("DROP TABLE tags;")},
+		"README.md":           {Data: []byte("не миграция")},
+	})
+	require.NoError(t, err)
+
+	applied, err := m.Up()
+	require.NoError(t, err)
+	assert.Len(t, applied, 2)
+	assert.True(t, db.Migrator().HasTable("tags"))
+
+	applied, err = m.Up()
+	require.NoError(t, err)
+	assert.Empty(t, applied, "повторный up ничего не применяет")
+
+	rolledBack, err := m.Down()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rolledBack.Version)
+	assert.False(t, db.Migrator().HasTable("tags"))
+	assert.True(t, db.Migrator().HasTable("items"))
+
+	statuses, err := m.Status()
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.NotNil(t, statuses[0].AppliedAt)
+	assert.Nil(t, statuses[1].AppliedAt)
+
+	_, err = m.Down()
+	require.NoError(t, err)
+	rolledBack, err = m.Down()
+	require.NoError(t, err)
+	assert.Nil(t, rolledBack)
+}
+
+func TestNoTransaction(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	m, err := newMigrator(db, fstest.MapFS{
+		"0001_items.up.sql":   {Data: []byte("CREATE TABLE items (id INTEGER PRIMARY KEY, name TEXT);")},
+		"0001_items.down.sql": {Data: []byte("DROP TABLE items;")},
+		"0002_index.up.sql": {Data: []byte(noTransaction + `
+-- Индекс по имени
+CREATE INDEX IF NOT EXISTS idx_items_name ON items (name);
+CREATE INDEX IF NOT EXISTS idx_items_id_name
+    ON items (id, name);
+-- конец файла
+`)},
+		"0002_index.down.sql": {Data: []byte("DROP INDEX idx_items_id_name; DROP INDEX idx_items_name;")},
+	})
+	require.NoError(t, err)
+
+	_, err = m.Up()
+	require.NoError(t, err)
+	assert.True(t, db.Migrator().HasIndex("items", "idx_items_name"))
+	assert.True(t, db.Migrator().HasIndex("items", "idx_items_id_name"))
+	statuses, err := m.Status()
+	require.NoError(t, err)
+	assert.NotNil(t, statuses[1].AppliedAt)
+
+	rolledBack, err := m.Down()
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rolledBack.Version)
+	assert.False(t, db.Migrator().HasIndex("items", "idx_items_name"))
+}
+
+func TestStatements(t *testing.T) {
+	body := noTransaction + "\n-- комментарий\nSELECT 1;\nSELECT 2\n  ;  \nSELECT ';'\n;\n-- хвост\n"
+	assert.Equal(t, []string{
+		noTransaction + "\n-- комментарий\nSELECT 1",
+		"SELECT 2",
+		"SELECT ';'",
+	}, statements(body))
+}
+
+func TestLoadRequiresPairs(t *testing.T) {
+	_, err := load(fstest.MapFS{"0001_items.up.sql": {Data: []byte("SELECT 1;")}})
+	assert.Error(t, err)
+}
+
+// Модели в том виде, в каком их создавал AutoMigrate до появления миграций
+type baselineUser struct {
+	ID        uint   `gorm:"primaryKey"`
+	Username  string `gorm:"not null;unique"`
+	CreatedAt time.Time
+}
+
+type baselinePost struct {
+	ID              uint   `gorm:"primaryKey"`
+	Title           string `gorm:"not null"`
+	Content         string `gorm:"not null"`
+	AuthorID        uint   `gorm:"not null"`
+	DisableComments bool   `gorm:"default:false"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+type baselineComment struct {
+	ID        uint `gorm:"primaryKey"`
+	PostID    uint `gorm:"not null"`
+	AuthorID  uint `gorm:"not null"`
+	ParentID  *uint
+	Content   string `gorm:"not null;size:2000"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (baselineUser) TableName() string    { return "users" }
+func (baselinePost) TableName() string    { return "posts" }
+func (baselineComment) TableName() string { return "comments" }
+
+// TestPostgresAutoMigrateBaseline переводит на миграции базу, созданную прежним
+// AutoMigrate. Запускается, только если задана TEST_DATABASE_URL; работает
+// в отдельной схеме, чтобы не мешать проверкам хранилища на той же базе.
+func TestPostgresAutoMigrateBaseline(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL не задана")
+	}
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, admin.Exec("DROP SCHEMA IF EXISTS migrate_baseline CASCADE").Error)
+	require.NoError(t, admin.Exec("CREATE SCHEMA migrate_baseline").Error)
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA IF EXISTS migrate_baseline CASCADE")
+	})
+
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	db, err := gorm.Open(postgres.Open(dsn+sep+"search_path=migrate_baseline"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&baselineUser{}, &baselinePost{}, &baselineComment{}))
+	require.NoError(t, db.Create(&baselineUser{Username: "old"}).Error)
+	require.NoError(t, db.Create(&baselinePost{Title: "p", Content: "t", AuthorID: 1}).Error)
+	require.NoError(t, db.Create(&baselineComment{PostID: 1, AuthorID: 1, Content: "c"}).Error)
+
+	m, err := New(db)
+	require.NoError(t, err)
+	_, err = m.Up()
+	require.NoError(t, err)
+
+	var user struct {
+		Username     string
+		PasswordHash string
+		IsAdmin      bool
+	}
+	require.NoError(t, db.Raw("SELECT username, password_hash, is_admin FROM users WHERE deleted_at IS NULL").Scan(&user).Error)
+	assert.Equal(t, "old", user.Username)
+	assert.False(t, user.IsAdmin)
+
+	var comments int64
+	require.NoError(t, db.Raw("SELECT COUNT(*) FROM comments WHERE deleted_at IS NULL AND NOT deleted AND edited_at IS NULL").Scan(&comments).Error)
+	assert.Equal(t, int64(1), comments)
+	var posts int64
+	require.NoError(t, db.Raw("SELECT COUNT(*) FROM posts WHERE deleted_at IS NULL AND comment_count = 1").Scan(&posts).Error)
+	assert.Equal(t, int64(1), posts)
+}
+
+// TestPostgresConcurrentUp запускает migrate up с нескольких реплик сразу.
+// Запускается, только если задана TEST_DATABASE_URL.
+func TestPostgresConcurrentUp(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL не задана")
+	}
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, admin.Exec("DROP SCHEMA IF EXISTS migrate_concurrent CASCADE").Error)
+	require.NoError(t, admin.Exec("CREATE SCHEMA migrate_concurrent").Error)
+	t.Cleanup(func() {
+		admin.Exec("DROP SCHEMA IF EXISTS migrate_concurrent CASCADE")
+	})
+
+	sep := "?"
+	if strings.Contains(dsn, "?") {
+		sep = "&"
+	}
+	const replicas = 4
+	errs := make(chan error, replicas)
+	for i := 0; i < replicas; i++ {
+		go func() {
+			db, err := gorm.Open(postgres.Open(dsn+sep+"search_path=migrate_concurrent"), &gorm.Config{})
+			if err != nil {
+				errs <- err
+				return
+			}
+			m, err := New(db)
+			if err != nil {
+				errs <- err
+				return
+			}
+			_, err = m.Up()
+			errs <- err
+		}()
+	}
+	for i := 0; i < replicas; i++ {
+		assert.NoError(t, <-errs)
+	}
+
+	var applied int64
+	require.NoError(t, admin.Raw("SELECT COUNT(*) FROM migrate_concurrent.schema_migrations").Scan(&applied).Error)
+	sub, err := fs.Sub(embedded, "postgres")
+	require.NoError(t, err)
+	migrations, err := load(sub)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(migrations)), applied)
+}
@@ -0,0 +1,57 @@
+-- Базовая схема совпадает с той, что создавал AutoMigrate, поэтому
+-- миграция безопасно применяется и к уже развёрнутым базам. Колонки,
+-- которых не было в первой версии моделей, в таких базах добавляются через ALTER.
+CREATE TABLE IF NOT EXISTS users (
+    id            BIGSERIAL PRIMARY KEY,
+    username      TEXT        NOT NULL,
+    password_hash TEXT        NOT NULL DEFAULT '',
+    is_admin      BOOLEAN     NOT NULL DEFAULT FALSE,
+    created_at    TIMESTAMPTZ,
+    deleted_at    TIMESTAMPTZ,
+    CONSTRAINT uni_users_username UNIQUE (username)
+);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS password_hash TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS posts (
+    id               BIGSERIAL PRIMARY KEY,
+    title            TEXT    NOT NULL,
+    content          TEXT    NOT NULL,
+    author_id        BIGINT  NOT NULL,
+    disable_comments BOOLEAN DEFAULT FALSE,
+    created_at       TIMESTAMPTZ,
+    updated_at       TIMESTAMPTZ,
+    deleted_at       TIMESTAMPTZ
+);
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_posts_deleted_at ON posts (deleted_at);
+
+CREATE TABLE IF NOT EXISTS comments (
+    id         BIGSERIAL PRIMARY KEY,
+    post_id    BIGINT        NOT NULL,
+    author_id  BIGINT        NOT NULL,
+    parent_id  BIGINT,
+    content    VARCHAR(2000) NOT NULL,
+    deleted    BOOLEAN       NOT NULL DEFAULT FALSE,
+    edited_at  TIMESTAMPTZ,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    deleted_at TIMESTAMPTZ
+);
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS edited_at TIMESTAMPTZ;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_comments_deleted_at ON comments (deleted_at);
+
+CREATE TABLE IF NOT EXISTS revisions (
+    id          BIGSERIAL PRIMARY KEY,
+    entity_type VARCHAR(16) NOT NULL,
+    entity_id   BIGINT      NOT NULL,
+    author_id   BIGINT      NOT NULL,
+    title       TEXT,
+    content     TEXT        NOT NULL,
+    created_at  TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_revisions_entity ON revisions (entity_type, entity_id);
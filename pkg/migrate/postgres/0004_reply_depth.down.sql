@@ -0,0 +1,2 @@
+ALTER TABLE comments DROP COLUMN IF EXISTS reply_to_id;
+ALTER TABLE posts DROP COLUMN IF EXISTS max_reply_depth;
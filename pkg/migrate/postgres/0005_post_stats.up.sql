@@ -0,0 +1,16 @@
+-- migrate:no-transaction
+-- Статистика обсуждения поста по видимым комментариям (без надгробий),
+-- пересчитывается хранилищем при изменении комментариев.
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS comment_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS participant_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN IF NOT EXISTS last_comment_at TIMESTAMPTZ;
+
+UPDATE posts SET
+    comment_count = (SELECT COUNT(*) FROM comments c WHERE c.post_id = posts.id AND c.deleted_at IS NULL AND NOT c.deleted),
+    participant_count = (SELECT COUNT(DISTINCT c.author_id) FROM comments c WHERE c.post_id = posts.id AND c.deleted_at IS NULL AND NOT c.deleted),
+    last_comment_at = (SELECT MAX(c.created_at) FROM comments c WHERE c.post_id = posts.id AND c.deleted_at IS NULL AND NOT c.deleted);
+
+-- Индексы под сортировки ленты MOST_REPLIES, MOST_PARTICIPANTS и RECENTLY_ACTIVE
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_posts_comment_count ON posts (comment_count, id);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_posts_participant_count ON posts (participant_count, id);
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_posts_activity ON posts ((COALESCE(last_comment_at, created_at)), id);
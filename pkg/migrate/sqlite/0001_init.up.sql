@@ -0,0 +1,47 @@
+CREATE TABLE IF NOT EXISTS users (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    username      TEXT     NOT NULL,
+    password_hash TEXT     NOT NULL DEFAULT '',
+    is_admin      NUMERIC  NOT NULL DEFAULT false,
+    created_at    DATETIME,
+    deleted_at    DATETIME,
+    CONSTRAINT uni_users_username UNIQUE (username)
+);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS posts (
+    id               INTEGER PRIMARY KEY AUTOINCREMENT,
+    title            TEXT    NOT NULL,
+    content          TEXT    NOT NULL,
+    author_id        INTEGER NOT NULL,
+    disable_comments NUMERIC DEFAULT false,
+    created_at       DATETIME,
+    updated_at       DATETIME,
+    deleted_at       DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_posts_deleted_at ON posts (deleted_at);
+
+CREATE TABLE IF NOT EXISTS comments (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id    INTEGER       NOT NULL,
+    author_id  INTEGER       NOT NULL,
+    parent_id  INTEGER,
+    content    VARCHAR(2000) NOT NULL,
+    deleted    NUMERIC       NOT NULL DEFAULT false,
+    edited_at  DATETIME,
+    created_at DATETIME,
+    updated_at DATETIME,
+    deleted_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_comments_deleted_at ON comments (deleted_at);
+
+CREATE TABLE IF NOT EXISTS revisions (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    entity_type VARCHAR(16) NOT NULL,
+    entity_id   INTEGER     NOT NULL,
+    author_id   INTEGER     NOT NULL,
+    title       TEXT,
+    content     TEXT        NOT NULL,
+    created_at  DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_revisions_entity ON revisions (entity_type, entity_id);
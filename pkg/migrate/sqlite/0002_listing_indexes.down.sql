@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_posts_created_at;
+DROP INDEX IF EXISTS idx_posts_author_id;
+DROP INDEX IF EXISTS idx_comments_parent_id_created_at;
+DROP INDEX IF EXISTS idx_comments_post_id_created_at;
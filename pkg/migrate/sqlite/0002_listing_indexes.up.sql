@@ -0,0 +1,5 @@
+-- Индексы под выборки ленты и веток: keyset-пагинация сортирует по created_at и id.
+CREATE INDEX IF NOT EXISTS idx_comments_post_id_created_at ON comments (post_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id_created_at ON comments (parent_id, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_posts_author_id ON posts (author_id);
+CREATE INDEX IF NOT EXISTS idx_posts_created_at ON posts (created_at, id);
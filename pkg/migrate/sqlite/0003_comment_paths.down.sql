@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_comments_post_id_path;
+ALTER TABLE comments DROP COLUMN depth;
+ALTER TABLE comments DROP COLUMN path;
@@ -0,0 +1,14 @@
+-- Материализованный путь комментария: id предков от корня через "/", у корневых пустой.
+ALTER TABLE comments ADD COLUMN path TEXT NOT NULL DEFAULT '';
+ALTER TABLE comments ADD COLUMN depth INTEGER NOT NULL DEFAULT 0;
+
+WITH RECURSIVE tree AS (
+    SELECT id, CAST('' AS TEXT) AS path, 0 AS depth FROM comments WHERE parent_id IS NULL
+    UNION ALL
+    SELECT c.id, t.path || CAST(c.parent_id AS TEXT) || '/', t.depth + 1
+    FROM comments c JOIN tree t ON c.parent_id = t.id
+)
+UPDATE comments SET path = tree.path, depth = tree.depth FROM tree WHERE comments.id = tree.id;
+
+-- Потомки ищутся по префиксу пути внутри поста
+CREATE INDEX IF NOT EXISTS idx_comments_post_id_path ON comments (post_id, path);
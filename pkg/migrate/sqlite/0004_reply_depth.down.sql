@@ -0,0 +1,2 @@
+ALTER TABLE comments DROP COLUMN reply_to_id;
+ALTER TABLE posts DROP COLUMN max_reply_depth;
@@ -0,0 +1,4 @@
+-- Ограничение вложенности ответов для отдельного поста и исходная цель
+-- ответа, прикреплённого к предку из-за этого ограничения.
+ALTER TABLE posts ADD COLUMN max_reply_depth INTEGER;
+ALTER TABLE comments ADD COLUMN reply_to_id INTEGER;
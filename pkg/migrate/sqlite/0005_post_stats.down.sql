@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_posts_activity;
+DROP INDEX IF EXISTS idx_posts_participant_count;
+DROP INDEX IF EXISTS idx_posts_comment_count;
+ALTER TABLE posts DROP COLUMN last_comment_at;
+ALTER TABLE posts DROP COLUMN participant_count;
+ALTER TABLE posts DROP COLUMN comment_count;
@@ -0,0 +1,15 @@
+-- Статистика обсуждения поста по видимым комментариям (без надгробий),
+-- пересчитывается хранилищем при изменении комментариев.
+ALTER TABLE posts ADD COLUMN comment_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN participant_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE posts ADD COLUMN last_comment_at DATETIME;
+
+UPDATE posts SET
+    comment_count = (SELECT COUNT(*) FROM comments c WHERE c.post_id = posts.id AND c.deleted_at IS NULL AND NOT c.deleted),
+    participant_count = (SELECT COUNT(DISTINCT c.author_id) FROM comments c WHERE c.post_id = posts.id AND c.deleted_at IS NULL AND NOT c.deleted),
+    last_comment_at = (SELECT MAX(c.created_at) FROM comments c WHERE c.post_id = posts.id AND c.deleted_at IS NULL AND NOT c.deleted);
+
+-- Индексы под сортировки ленты MOST_REPLIES, MOST_PARTICIPANTS и RECENTLY_ACTIVE
+CREATE INDEX IF NOT EXISTS idx_posts_comment_count ON posts (comment_count, id);
+CREATE INDEX IF NOT EXISTS idx_posts_participant_count ON posts (participant_count, id);
+CREATE INDEX IF NOT EXISTS idx_posts_activity ON posts ((COALESCE(last_comment_at, created_at)), id);
@@ -0,0 +1,208 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrSlowConsumer = errors.New("subscriber queue overflow")
+
+// OverflowPolicy определяет, что делать с событием, если очередь подписчика заполнена.
+type OverflowPolicy string
+
+const (
+	// DropOldest вытесняет самое старое событие из очереди.
+	DropOldest OverflowPolicy = "drop_oldest"
+	// Disconnect закрывает подписку с ошибкой ErrSlowConsumer.
+	Disconnect OverflowPolicy = "disconnect"
+	// Block ждёт освобождения места не дольше BlockTimeout, затем отбрасывает событие.
+	Block OverflowPolicy = "block"
+)
+
+const (
+	defaultQueueSize    = 64
+	defaultBlockTimeout = time.Second
+)
+
+type Options struct {
+	QueueSize    int
+	Overflow     OverflowPolicy
+	BlockTimeout time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.QueueSize <= 0 {
+		o.QueueSize = defaultQueueSize
+	}
+	if o.Overflow == "" {
+		o.Overflow = DropOldest
+	}
+	if o.BlockTimeout <= 0 {
+		o.BlockTimeout = defaultBlockTimeout
+	}
+	return o
+}
+
+func (o Options) validate() error {
+	switch o.Overflow {
+	case "", DropOldest, Disconnect, Block:
+		return nil
+	default:
+		return fmt.Errorf("unknown overflow policy: %s", o.Overflow)
+	}
+}
+
+// Subscription — ограниченная очередь событий одного подписчика.
+// Events закрывается при отмене контекста подписки или при отключении
+// медленного подписчика; в последнем случае Err возвращает ErrSlowConsumer.
+type Subscription struct {
+	events  chan CommentEvent
+	done    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex // упорядочивает запись в events и его закрытие
+	err     error
+	dropped atomic.Uint64
+}
+
+func (s *Subscription) Events() <-chan CommentEvent {
+	return s.events
+}
+
+// Dropped возвращает число событий, не доставленных подписчику.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) close(err error) {
+	s.once.Do(func() {
+		close(s.done)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.err = err
+		close(s.events)
+	})
+}
+
+// deliver кладёт событие в очередь согласно политике. Возвращает false,
+// если подписчика нужно отключить.
+func (s *Subscription) deliver(event CommentEvent, opts Options) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.done:
+		return true
+	default:
+	}
+
+	select {
+	case s.events <- event:
+		return true
+	default:
+	}
+
+	switch opts.Overflow {
+	case Disconnect:
+		s.dropped.Add(1)
+		return false
+	case Block:
+		timer := time.NewTimer(opts.BlockTimeout)
+		defer timer.Stop()
+
+		select {
+		case s.events <- event:
+		case <-timer.C:
+			s.dropped.Add(1)
+		case <-s.done:
+		}
+		return true
+	default:
+		// Пишем только мы и под s.mu, поэтому после вытеснения место точно есть
+		select {
+		case <-s.events:
+			s.dropped.Add(1)
+		default:
+		}
+		s.events <- event
+		return true
+	}
+}
+
+// hub раздаёт события локальным подписчикам. Используется и как
+// самостоятельная реализация, и как приёмник уведомлений из Postgres.
+type hub struct {
+	opts        Options
+	mu          sync.Mutex
+	subscribers map[uint]map[*Subscription]struct{}
+}
+
+func newHub(opts Options) *hub {
+	return &hub{
+		opts:        opts.withDefaults(),
+		subscribers: make(map[uint]map[*Subscription]struct{}),
+	}
+}
+
+func (h *hub) subscribe(ctx context.Context, postID uint) *Subscription {
+	sub := &Subscription{
+		events: make(chan CommentEvent, h.opts.QueueSize),
+		done:   make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	if h.subscribers[postID] == nil {
+		h.subscribers[postID] = make(map[*Subscription]struct{})
+	}
+	h.subscribers[postID][sub] = struct{}{}
+	h.mu.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-sub.done:
+		}
+		h.remove(postID, sub)
+		sub.close(nil)
+	}()
+
+	return sub
+}
+
+func (h *hub) remove(postID uint, sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.subscribers[postID], sub)
+	if len(h.subscribers[postID]) == 0 {
+		delete(h.subscribers, postID)
+	}
+}
+
+// publish доставляет событие подписчикам поста по очереди, не удерживая
+// h.mu: при политике Block медленный подписчик задерживает публикацию
+// не больше чем на BlockTimeout.
+func (h *hub) publish(event CommentEvent) {
+	h.mu.Lock()
+	subs := make([]*Subscription, 0, len(h.subscribers[event.PostID]))
+	for sub := range h.subscribers[event.PostID] {
+		subs = append(subs, sub)
+	}
+	h.mu.Unlock()
+
+	for _, sub := range subs {
+		if !sub.deliver(event, h.opts) {
+			sub.close(ErrSlowConsumer)
+		}
+	}
+}
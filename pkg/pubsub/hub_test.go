@@ -0,0 +1,91 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverflowPolicies(t *testing.T) {
+	publish := func(m *Memory, ids ...uint) {
+		for _, id := range ids {
+			_ = m.Publish(context.Background(), CommentEvent{PostID: 1, CommentID: id})
+		}
+	}
+	drain := func(sub *Subscription) []uint {
+		var ids []uint
+		for {
+			select {
+			case event, ok := <-sub.Events():
+				if !ok {
+					return ids
+				}
+				ids = append(ids, event.CommentID)
+			default:
+				return ids
+			}
+		}
+	}
+
+	t.Run("drop oldest", func(t *testing.T) {
+		m := NewMemory(Options{QueueSize: 2, Overflow: DropOldest})
+		sub, _ := m.Subscribe(context.Background(), 1)
+
+		publish(m, 1, 2, 3, 4)
+		assert.Equal(t, []uint{3, 4}, drain(sub))
+		assert.Equal(t, uint64(2), sub.Dropped())
+		assert.NoError(t, sub.Err())
+	})
+
+	t.Run("disconnect", func(t *testing.T) {
+		m := NewMemory(Options{QueueSize: 2, Overflow: Disconnect})
+		sub, _ := m.Subscribe(context.Background(), 1)
+
+		publish(m, 1, 2, 3, 4)
+		assert.Equal(t, []uint{1, 2}, drain(sub))
+		_, ok := <-sub.Events()
+		assert.False(t, ok)
+		assert.ErrorIs(t, sub.Err(), ErrSlowConsumer)
+		assert.Equal(t, uint64(1), sub.Dropped())
+	})
+
+	t.Run("block with timeout", func(t *testing.T) {
+		m := NewMemory(Options{QueueSize: 1, Overflow: Block, BlockTimeout: 20 * time.Millisecond})
+		sub, _ := m.Subscribe(context.Background(), 1)
+
+		publish(m, 1, 2)
+		assert.Equal(t, []uint{1}, drain(sub))
+		assert.Equal(t, uint64(1), sub.Dropped())
+
+	})
+
+	t.Run("block until read", func(t *testing.T) {
+		m := NewMemory(Options{QueueSize: 1, Overflow: Block, BlockTimeout: time.Minute})
+		sub, _ := m.Subscribe(context.Background(), 1)
+
+		publish(m, 1)
+		done := make(chan struct{})
+		go func() {
+			publish(m, 2)
+			close(done)
+		}()
+		<-sub.Events()
+		<-done
+		assert.Equal(t, []uint{2}, drain(sub))
+		assert.Equal(t, uint64(0), sub.Dropped())
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		m := NewMemory(Options{})
+		ctx, cancel := context.WithCancel(context.Background())
+		sub, _ := m.Subscribe(ctx, 1)
+
+		cancel()
+		_, ok := <-sub.Events()
+		assert.False(t, ok)
+		assert.NoError(t, sub.Err())
+		publish(m, 1)
+	})
+}
@@ -0,0 +1,25 @@
+package pubsub
+
+import "context"
+
+// Memory — pub/sub в пределах одного процесса.
+type Memory struct {
+	hub *hub
+}
+
+func NewMemory(opts Options) *Memory {
+	return &Memory{hub: newHub(opts)}
+}
+
+func (m *Memory) Publish(ctx context.Context, event CommentEvent) error {
+	m.hub.publish(event)
+	return nil
+}
+
+func (m *Memory) Subscribe(ctx context.Context, postID uint) (*Subscription, error) {
+	return m.hub.subscribe(ctx, postID), nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
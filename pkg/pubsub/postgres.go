@@ -0,0 +1,119 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultChannel = "new_comments"
+	reconnectDelay = time.Second
+)
+
+// Postgres рассылает события через NOTIFY и слушает их на отдельном
+// соединении из пула storage.DB, поэтому подписчики получают комментарии,
+// созданные на любой реплике. Собственные уведомления тоже приходят
+// через LISTEN, так что локальной доставки в обход базы нет.
+// При политике Block медленный подписчик задерживает чтение уведомлений.
+type Postgres struct {
+	db      *gorm.DB
+	channel string
+	hub     *hub
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func NewPostgres(db *gorm.DB, channel string, opts Options) *Postgres {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Postgres{
+		db:      db,
+		channel: channel,
+		hub:     newHub(opts),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go p.listen(ctx)
+	return p
+}
+
+func (p *Postgres) Publish(ctx context.Context, event CommentEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return p.db.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", p.channel, string(payload)).Error
+}
+
+func (p *Postgres) Subscribe(ctx context.Context, postID uint) (*Subscription, error) {
+	return p.hub.subscribe(ctx, postID), nil
+}
+
+func (p *Postgres) Close() error {
+	p.cancel()
+	<-p.done
+	return nil
+}
+
+// listen держит LISTEN и переподключается после обрыва соединения.
+func (p *Postgres) listen(ctx context.Context) {
+	defer close(p.done)
+
+	for {
+		err := p.listenConn(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Соединение LISTEN %s потеряно: %v", p.channel, err)
+
+		select {
+		case <-time.After(reconnectDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *Postgres) listenConn(ctx context.Context) error {
+	sqlDB, err := p.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		stdConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected driver connection %T", driverConn)
+		}
+		pgConn := stdConn.Conn()
+
+		if _, err := pgConn.Exec(ctx, "LISTEN "+pgx.Identifier{p.channel}.Sanitize()); err != nil {
+			return err
+		}
+
+		for {
+			notification, err := pgConn.WaitForNotification(ctx)
+			if err != nil {
+				return err
+			}
+
+			var event CommentEvent
+			if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+				log.Printf("Некорректное уведомление в %s: %v", p.channel, err)
+				continue
+			}
+			p.hub.publish(event)
+		}
+	})
+}
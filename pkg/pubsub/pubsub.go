@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"gorm.io/gorm"
+)
+
+type EventType string
+
+const (
+	CommentCreated EventType = "created"
+	CommentUpdated EventType = "updated"
+	CommentDeleted EventType = "deleted"
+	// PostUpdated — изменились заголовок или текст самого поста, CommentID пуст
+	PostUpdated EventType = "post_updated"
+)
+
+// CommentEvent сообщает об изменении комментария к посту. Передаются только
+// идентификаторы: сам комментарий подписчик читает из хранилища. Исключение —
+// полностью удалённый комментарий, его снимок приходит в Removed.
+type CommentEvent struct {
+	Type      EventType       `json:"type"`
+	PostID    uint            `json:"post_id"`
+	CommentID uint            `json:"comment_id"`
+	Removed   *models.Comment `json:"removed,omitempty"`
+}
+
+// PubSub доставляет события о комментариях подписчикам поста.
+// Подписка завершается после отмены ctx.
+type PubSub interface {
+	Publish(ctx context.Context, event CommentEvent) error
+	Subscribe(ctx context.Context, postID uint) (*Subscription, error)
+	Close() error
+}
+
+// New создаёт реализацию по имени: memory работает в пределах одного процесса,
+// postgres рассылает события между репликами через LISTEN/NOTIFY.
+func New(pubsubType string, db *gorm.DB, opts Options) (PubSub, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	switch pubsubType {
+	case "memory":
+		return NewMemory(opts), nil
+	case "postgres":
+		if db == nil || db.Dialector.Name() != "postgres" {
+			return nil, fmt.Errorf("postgres pubsub requires a postgres database connection")
+		}
+		return NewPostgres(db, DefaultChannel, opts), nil
+	default:
+		return nil, fmt.Errorf("unknown pubsub type: %s", pubsubType)
+	}
+}
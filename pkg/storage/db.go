@@ -2,9 +2,13 @@ package storage
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
+	"time"
 
+	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
@@ -17,21 +21,74 @@ var (
 func InitStorage(storageType string) error {
 	switch storageType {
 	case "memory":
-		Store = NewMemoryStorage()
-		log.Println("Подключено к memory")
+		dir := os.Getenv("MEMORY_DATA_DIR")
+		if dir == "" {
+			Store = NewMemoryStorage()
+			log.Println("Подключено к memory")
+			return nil
+		}
+		opts := PersistOptions{Dir: dir}
+		if every := os.Getenv("MEMORY_SNAPSHOT_EVERY"); every != "" {
+			parsed, err := strconv.Atoi(every)
+			if err != nil {
+				return fmt.Errorf("некорректное значение MEMORY_SNAPSHOT_EVERY: %v", err)
+			}
+			opts.SnapshotEvery = parsed
+		}
+		store, err := OpenMemoryStorage(opts)
+		if err != nil {
+			return fmt.Errorf("ошибка восстановления memory из %s: %v", dir, err)
+		}
+		Store = store
+		log.Println("Подключено к memory, данные сохраняются в", dir)
 		return nil
 	case "postgres":
 		if err := ConnectDatabase(); err != nil {
 			return err
 		}
-		Store = NewPostgresStorage(DB)
+		opts, err := postgresOptions()
+		if err != nil {
+			return err
+		}
+		Store = NewPostgresStorage(DB, opts)
 		log.Println("Подключено к postgres")
 		return nil
+	case "sqlite":
+		if err := ConnectSQLite(); err != nil {
+			return err
+		}
+		opts, err := postgresOptions()
+		if err != nil {
+			return err
+		}
+		Store = NewPostgresStorage(DB, opts)
+		log.Println("Подключено к sqlite")
+		return nil
 	default:
 		return fmt.Errorf("unknown storage type: %s", storageType)
 	}
 }
 
+func postgresOptions() (PostgresOptions, error) {
+	var opts PostgresOptions
+	if timeout := os.Getenv("STORAGE_QUERY_TIMEOUT"); timeout != "" {
+		parsed, err := time.ParseDuration(timeout)
+		if err != nil {
+			return opts, fmt.Errorf("некорректное значение STORAGE_QUERY_TIMEOUT: %v", err)
+		}
+		opts.QueryTimeout = parsed
+	}
+	return opts, nil
+}
+
+// Close освобождает ресурсы хранилища, если они у него есть.
+func Close() error {
+	if closer, ok := Store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
 func ConnectDatabase() error {
 	dsn := os.Getenv("DATABASE_URL")
 	if dsn == "" {
@@ -47,7 +104,7 @@ func ConnectDatabase() error {
 			host, port, user, password, dbname)
 	}
 
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := OpenPostgres(dsn)
 	if err != nil {
 		return fmt.Errorf("ошибка подключения к базе данных: %v", err)
 	}
@@ -56,3 +113,41 @@ func ConnectDatabase() error {
 	log.Println("Подключение к базе данных успешно!")
 	return nil
 }
+
+// ConnectSQLite открывает файл базы из SQLITE_PATH.
+func ConnectSQLite() error {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = "data.db"
+	}
+
+	db, err := OpenSQLite(path)
+	if err != nil {
+		return fmt.Errorf("ошибка открытия базы sqlite: %v", err)
+	}
+
+	DB = db
+	log.Println("Подключение к базе данных успешно!")
+	return nil
+}
+
+func OpenPostgres(dsn string) (*gorm.DB, error) {
+	return gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
+}
+
+// OpenSQLite открывает файл базы с единственным соединением: SQLite всё равно
+// допускает только одного писателя, а так запросы встают в очередь
+// вместо ошибок SQLITE_BUSY.
+func OpenSQLite(path string) (*gorm.DB, error) {
+	dsn := path + "?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{TranslateError: true})
+	if err != nil {
+		return nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	sqlDB.SetMaxOpenConns(1)
+	return db, nil
+}
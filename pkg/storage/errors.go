@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// Категории ошибок хранилища. Проверяются через errors.Is, поэтому
+// вызывающему коду не нужно разбирать текст сообщения.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrForbidden  = errors.New("forbidden")
+	ErrValidation = errors.New("validation failed")
+)
+
+// Error связывает сообщение для клиента с одной из категорий выше.
+type Error struct {
+	Kind    error
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+func NewError(kind error, message string) error {
+	return &Error{Kind: kind, Message: message}
+}
+
+func NotFound(entity string) error {
+	return NewError(ErrNotFound, entity+" not found")
+}
+
+func Conflict(entity string) error {
+	return NewError(ErrConflict, entity+" already exists")
+}
+
+// translateError приводит ошибки GORM к категориям хранилища.
+func translateError(entity string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return NotFound(entity)
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return Conflict(entity)
+	default:
+		return err
+	}
+}
@@ -1,27 +1,110 @@
 package storage
 
 import (
-	"errors"
+	"context"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"gorm.io/gorm"
 )
 
 type MemoryStorage struct {
-	users    map[uint]*models.User
-	posts    map[uint]*models.Post
-	comments map[uint]*models.Comment
-	lastID   uint
-	mu       sync.RWMutex
+	*memoryState
+	// inTx — представление внутри WithTx: блокировка уже взята вызывающим
+	inTx bool
+}
+
+type memoryState struct {
+	users     map[uint]*models.User
+	posts     map[uint]*models.Post
+	comments  map[uint]*models.Comment
+	revisions map[uint]*models.Revision
+	// rootComments и replies — id комментариев верхнего уровня поста и ответов
+	// на комментарий, в порядке добавления. Учитывают и мягко удалённые.
+	rootComments map[uint][]uint
+	replies      map[uint][]uint
+	lastID       uint
+	lastTime     time.Time
+	mu           sync.RWMutex
+	// journal не nil, если хранилище открыто через OpenMemoryStorage
+	journal *memoryLog
 }
 
 func NewMemoryStorage() *MemoryStorage {
-	return &MemoryStorage{
-		users:    make(map[uint]*models.User),
-		posts:    make(map[uint]*models.Post),
-		comments: make(map[uint]*models.Comment),
-		lastID:   0,
+	return &MemoryStorage{memoryState: &memoryState{
+		users:     make(map[uint]*models.User),
+		posts:     make(map[uint]*models.Post),
+		comments:  make(map[uint]*models.Comment),
+		revisions: make(map[uint]*models.Revision),
+		lastID:    0,
+
+		rootComments: make(map[uint][]uint),
+		replies:      make(map[uint][]uint),
+	}}
+}
+
+// WithTx выполняет fn под блокировкой хранилища, так что проверки и запись
+// внутри fn не перемежаются с другими вызовами. Откатывать изменения
+// MemoryStorage не умеет: записанное до ошибки остаётся, поэтому fn
+// должна проверять всё до первой записи.
+func (s *MemoryStorage) WithTx(ctx context.Context, fn func(tx Storage) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := fn(&MemoryStorage{memoryState: s.memoryState, inTx: true})
+	if flushErr := s.flush(); err == nil {
+		err = flushErr
+	}
+	return err
+}
+
+func (s *MemoryStorage) lock() {
+	if !s.inTx {
+		s.mu.Lock()
+	}
+}
+
+func (s *MemoryStorage) unlock() {
+	if !s.inTx {
+		s.mu.Unlock()
+	}
+}
+
+func (s *MemoryStorage) rlock() {
+	if !s.inTx {
+		s.mu.RLock()
+	}
+}
+
+func (s *MemoryStorage) runlock() {
+	if !s.inTx {
+		s.mu.RUnlock()
+	}
+}
+
+// now выдаёт время с точностью до микросекунд, как его хранит PostgreSQL.
+// Отметки строго возрастают, поэтому порядок по времени совпадает
+// с порядком изменений даже на грубых часах.
+// Вызывается под s.mu.
+func (s *MemoryStorage) now() time.Time {
+	t := time.Now().Truncate(time.Microsecond)
+	if !t.After(s.lastTime) {
+		t = s.lastTime.Add(time.Microsecond)
+	}
+	s.lastTime = t
+	return t
+}
+
+// setTimestamp, как и GORM, не трогает заданное вызывающим время.
+func (s *MemoryStorage) setTimestamp(t *time.Time) {
+	if t.IsZero() {
+		*t = s.now()
 	}
 }
 
@@ -30,89 +113,149 @@ func (s *MemoryStorage) nextID() uint {
 	return s.lastID
 }
 
-func (s *MemoryStorage) CreateUser(user *models.User) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *MemoryStorage) CreateUser(ctx context.Context, user *models.User) error {
+	s.lock()
+	defer s.unlock()
+
+	for _, existing := range s.users {
+		if existing.Username == user.Username {
+			return Conflict("user")
+		}
+	}
 
 	user.ID = s.nextID()
-	user.CreatedAt = time.Now()
-	s.users[user.ID] = user
-	return nil
+	s.setTimestamp(&user.CreatedAt)
+	s.putUser(user)
+	return s.commit("CreateUser")
 }
 
-func (s *MemoryStorage) GetUser(id uint) (*models.User, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *MemoryStorage) GetUser(ctx context.Context, id uint) (*models.User, error) {
+	s.rlock()
+	defer s.runlock()
 
-	if user, ok := s.users[id]; ok {
+	if user, ok := s.users[id]; ok && !user.DeletedAt.Valid {
 		return user, nil
 	}
-	return nil, errors.New("user not found")
+	return nil, NotFound("user")
 }
 
-func (s *MemoryStorage) CreatePost(post *models.Post) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *MemoryStorage) GetUsersByIDs(ctx context.Context, ids []uint) ([]*models.User, error) {
+	s.rlock()
+	defer s.runlock()
+
+	users := make([]*models.User, 0, len(ids))
+	for _, id := range ids {
+		if user, ok := s.users[id]; ok {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (s *MemoryStorage) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	s.rlock()
+	defer s.runlock()
+
+	for _, user := range s.users {
+		if user.Username == username && !user.DeletedAt.Valid {
+			return user, nil
+		}
+	}
+	return nil, NotFound("user")
+}
+
+func (s *MemoryStorage) CreatePost(ctx context.Context, post *models.Post) error {
+	s.lock()
+	defer s.unlock()
 
 	post.ID = s.nextID()
-	post.CreatedAt = time.Now()
-	post.UpdatedAt = time.Now()
-	s.posts[post.ID] = post
-	return nil
+	s.setTimestamp(&post.CreatedAt)
+	s.setTimestamp(&post.UpdatedAt)
+	s.putPost(post)
+	return s.commit("CreatePost")
 }
 
-func (s *MemoryStorage) GetPost(id uint) (*models.Post, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *MemoryStorage) GetPost(ctx context.Context, id uint) (*models.Post, error) {
+	s.rlock()
+	defer s.runlock()
 
-	if post, ok := s.posts[id]; ok {
+	if post, ok := s.livePost(id); ok {
 		return post, nil
 	}
-	return nil, errors.New("post not found")
+	return nil, NotFound("post")
 }
 
-func (s *MemoryStorage) GetPosts() ([]*models.Post, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *MemoryStorage) GetPostsByIDs(ctx context.Context, ids []uint) ([]*models.Post, error) {
+	s.rlock()
+	defer s.runlock()
 
-	posts := make([]*models.Post, 0, len(s.posts))
-	for _, post := range s.posts {
-		posts = append(posts, post)
+	posts := make([]*models.Post, 0, len(ids))
+	for _, id := range ids {
+		if post, ok := s.livePost(id); ok {
+			posts = append(posts, post)
+		}
 	}
 	return posts, nil
 }
 
-func (s *MemoryStorage) CreateComment(comment *models.Comment) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *MemoryStorage) GetPosts(ctx context.Context, order SortOrder) ([]*models.Post, error) {
+	s.rlock()
+	defer s.runlock()
+
+	return s.postsPage(s.livePosts(), PageArgs{Order: order}).Items, nil
+}
+
+func (s *MemoryStorage) GetPostsPage(ctx context.Context, page PageArgs) (*Page[*models.Post], error) {
+	s.rlock()
+	defer s.runlock()
+
+	return s.postsPage(s.livePosts(), page), nil
+}
+
+func (s *MemoryStorage) CreateComment(ctx context.Context, comment *models.Comment) error {
+	s.lock()
+	defer s.unlock()
+
+	comment.Path, comment.Depth = "", 0
+	if comment.ParentID != nil {
+		parent, ok := s.comments[*comment.ParentID]
+		if !ok {
+			return NotFound("comment")
+		}
+		placeUnder(comment, parent)
+	}
 
 	comment.ID = s.nextID()
-	comment.CreatedAt = time.Now()
-	comment.UpdatedAt = time.Now()
-	s.comments[comment.ID] = comment
-	return nil
+	s.setTimestamp(&comment.CreatedAt)
+	s.setTimestamp(&comment.UpdatedAt)
+	s.putComment(comment)
+	s.refreshPostStats(comment.PostID)
+	return s.commit("CreateComment")
 }
 
-func (s *MemoryStorage) GetComment(id uint) (*models.Comment, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *MemoryStorage) GetComment(ctx context.Context, id uint) (*models.Comment, error) {
+	s.rlock()
+	defer s.runlock()
 
-	if comment, ok := s.comments[id]; ok {
+	if comment, ok := s.liveComment(id); ok {
 		return comment, nil
 	}
-	return nil, errors.New("comment not found")
+	return nil, NotFound("comment")
 }
 
-func (s *MemoryStorage) GetComments(postID uint, limit, offset *int32) ([]*models.Comment, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *MemoryStorage) GetCommentsByIDs(ctx context.Context, ids []uint) ([]*models.Comment, error) {
+	s.rlock()
+	defer s.runlock()
 
-	var comments []*models.Comment
-	for _, comment := range s.comments {
-		if comment.PostID == postID && comment.ParentID == nil {
-			comments = append(comments, comment)
-		}
-	}
+	return s.liveCommentsByIDs(ids), nil
+}
+
+func (s *MemoryStorage) GetComments(ctx context.Context, postID uint, limit, offset *int32, order SortOrder) ([]*models.Comment, error) {
+	s.rlock()
+	defer s.runlock()
+
+	comments := s.liveCommentsByIDs(s.rootComments[postID])
+	comments = s.commentsPage(comments, PageArgs{Order: order}).Items
 
 	if offset != nil {
 		start := int(*offset)
@@ -133,27 +276,646 @@ func (s *MemoryStorage) GetComments(postID uint, limit, offset *int32) ([]*model
 	return comments, nil
 }
 
-func (s *MemoryStorage) GetCommentChildren(parentID uint) ([]*models.Comment, error) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
+func (s *MemoryStorage) GetCommentsPage(ctx context.Context, postID uint, page PageArgs) (*Page[*models.Comment], error) {
+	s.rlock()
+	defer s.runlock()
 
-	var children []*models.Comment
-	for _, comment := range s.comments {
-		if comment.ParentID != nil && *comment.ParentID == parentID {
-			children = append(children, comment)
+	comments := s.liveCommentsByIDs(s.rootComments[postID])
+	return s.commentsPage(comments, page), nil
+}
+
+func (s *MemoryStorage) GetRepliesPage(ctx context.Context, parentID uint, page PageArgs) (*Page[*models.Comment], error) {
+	s.rlock()
+	defer s.runlock()
+
+	if page.AfterID != 0 {
+		// Последний показанный ответ мог быть удалён, пока клиент листал ветку
+		after, ok := s.comments[page.AfterID]
+		if !ok || after.ParentID == nil || *after.ParentID != parentID {
+			return nil, ErrInvalidCursor
 		}
+		stats := s.replyActivity(page.Order, []*models.Comment{after})
+		cursor := cursorFor(page.Order, after.ID, after.CreatedAt, stats[after.ID])
+		page.After = &cursor
+	}
+	return s.commentsPage(s.liveCommentsByIDs(s.replies[parentID]), page), nil
+}
+
+func (s *MemoryStorage) GetReplyCounts(ctx context.Context, ids []uint) (map[uint]ReplyCounts, error) {
+	s.rlock()
+	defer s.runlock()
+
+	counts := make(map[uint]ReplyCounts, len(ids))
+	for _, id := range ids {
+		var c ReplyCounts
+		c.Replies = len(s.liveCommentsByIDs(s.replies[id]))
+		queue := []uint{id}
+		for len(queue) > 0 {
+			replies := s.liveCommentsByIDs(s.replies[queue[0]])
+			queue = queue[1:]
+			for _, reply := range replies {
+				c.Descendants++
+				queue = append(queue, reply.ID)
+			}
+		}
+		counts[id] = c
+	}
+	return counts, nil
+}
+
+func (s *MemoryStorage) GetCommentChildren(ctx context.Context, parentID uint, order SortOrder) ([]*models.Comment, error) {
+	s.rlock()
+	defer s.runlock()
+
+	children := s.liveCommentsByIDs(s.replies[parentID])
+	return s.commentsPage(children, PageArgs{Order: order}).Items, nil
+}
+
+func (s *MemoryStorage) GetChildrenByParentIDs(ctx context.Context, parentIDs []uint, order SortOrder, limit int) (map[uint][]*models.Comment, error) {
+	s.rlock()
+	defer s.runlock()
+
+	children := make(map[uint][]*models.Comment, len(parentIDs))
+	for _, id := range parentIDs {
+		replies := s.liveCommentsByIDs(s.replies[id])
+		children[id] = s.commentsPage(replies, PageArgs{First: limit, Order: order}).Items
 	}
 	return children, nil
 }
 
-func (s *MemoryStorage) UpdatePost(post *models.Post) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+func (s *MemoryStorage) GetCommentTree(ctx context.Context, postID uint, rootLimit, maxDepth int) ([]*ThreadComment, error) {
+	s.rlock()
+	defer s.runlock()
+
+	roots := s.liveCommentsByIDs(s.rootComments[postID])
+	return flattenThread(roots, rootLimit, maxDepth, func(parentID uint) []*models.Comment {
+		return s.liveCommentsByIDs(s.replies[parentID])
+	}), nil
+}
+
+func (s *MemoryStorage) GetCommentDescendants(ctx context.Context, id uint, limit int) ([]*models.Comment, error) {
+	s.rlock()
+	defer s.runlock()
+
+	if _, ok := s.liveComment(id); !ok {
+		return nil, NotFound("comment")
+	}
+
+	descendants := []*models.Comment{}
+	queue := []uint{id}
+	for len(queue) > 0 {
+		replies := s.liveCommentsByIDs(s.replies[queue[0]])
+		queue = queue[1:]
+		for _, reply := range replies {
+			descendants = append(descendants, reply)
+			queue = append(queue, reply.ID)
+		}
+	}
+
+	sortOldest(descendants)
+	if limit > 0 && len(descendants) > limit {
+		descendants = descendants[:limit]
+	}
+	return descendants, nil
+}
+
+func (s *MemoryStorage) GetCommentsAfter(ctx context.Context, postID, afterID uint) ([]*models.Comment, error) {
+	s.rlock()
+	defer s.runlock()
+
+	comments := []*models.Comment{}
+	for _, comment := range s.liveComments() {
+		if comment.PostID == postID && comment.ID > afterID {
+			comments = append(comments, comment)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		return comments[i].ID < comments[j].ID
+	})
+	return comments, nil
+}
+
+func (s *MemoryStorage) UpdatePost(ctx context.Context, post *models.Post) error {
+	s.lock()
+	defer s.unlock()
+
+	old, ok := s.livePost(post.ID)
+	if !ok {
+		return NotFound("post")
+	}
+	if old.Title != post.Title || old.Content != post.Content {
+		s.addRevision(models.RevisionPost, old.ID, old.AuthorID, old.Title, old.Content)
+	}
+	post.CommentCount, post.ParticipantCount, post.LastCommentAt = old.CommentCount, old.ParticipantCount, old.LastCommentAt
+	post.UpdatedAt = s.now()
+	s.putPost(post)
+	return s.commit("UpdatePost")
+}
+
+func (s *MemoryStorage) UpdateComment(ctx context.Context, comment *models.Comment) error {
+	s.lock()
+	defer s.unlock()
+
+	old, ok := s.liveComment(comment.ID)
+	if !ok {
+		return NotFound("comment")
+	}
+	if old.Content != comment.Content {
+		s.addRevision(models.RevisionComment, old.ID, old.AuthorID, "", old.Content)
+	}
+	comment.UpdatedAt = s.now()
+	s.putComment(comment)
+	return s.commit("UpdateComment")
+}
+
+func (s *MemoryStorage) DeleteComment(ctx context.Context, id uint) (*models.Comment, error) {
+	s.lock()
+	defer s.unlock()
+
+	comment, ok := s.liveComment(id)
+	if !ok {
+		return nil, NotFound("comment")
+	}
+
+	if s.hasReplies(id) {
+		s.addRevision(models.RevisionComment, comment.ID, comment.AuthorID, "", comment.Content)
+		tombstone := *comment
+		tombstone.Content = DeletedContent
+		tombstone.Deleted = true
+		tombstone.UpdatedAt = s.now()
+		s.putComment(&tombstone)
+		s.refreshPostStats(comment.PostID)
+		if err := s.commit("DeleteComment"); err != nil {
+			return nil, err
+		}
+		return &tombstone, nil
+	}
 
-	if _, ok := s.posts[post.ID]; !ok {
-		return errors.New("post not found")
+	s.removeComment(comment, gorm.DeletedAt{Time: s.now(), Valid: true})
+	s.refreshPostStats(comment.PostID)
+	return nil, s.commit("DeleteComment")
+}
+
+func (s *MemoryStorage) hasReplies(id uint) bool {
+	for _, replyID := range s.replies[id] {
+		if _, ok := s.liveComment(replyID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// removeComment мягко удаляет комментарий и поднимается вверх по ветке,
+// убирая надгробия родителей, у которых не осталось ответов.
+func (s *MemoryStorage) removeComment(comment *models.Comment, deletedAt gorm.DeletedAt) {
+	for {
+		removed := *comment
+		removed.DeletedAt = deletedAt
+		s.putComment(&removed)
+		if comment.ParentID == nil {
+			return
+		}
+		parent, ok := s.liveComment(*comment.ParentID)
+		if !ok || !parent.Deleted || s.hasReplies(parent.ID) {
+			return
+		}
+		comment = parent
+	}
+}
+
+func (s *MemoryStorage) DeletePost(ctx context.Context, id uint) error {
+	s.lock()
+	defer s.unlock()
+
+	post, ok := s.livePost(id)
+	if !ok {
+		return NotFound("post")
+	}
+
+	// Комментарии получают ту же отметку, что и пост, чтобы RestorePost
+	// вернул ровно их, не трогая удалённые раньше по отдельности.
+	deletedAt := gorm.DeletedAt{Time: s.now(), Valid: true}
+	for _, comment := range s.liveComments() {
+		if comment.PostID == id {
+			removed := *comment
+			removed.DeletedAt = deletedAt
+			s.putComment(&removed)
+		}
+	}
+	removed := *post
+	removed.DeletedAt = deletedAt
+	s.putPost(&removed)
+	return s.commit("DeletePost")
+}
+
+func (s *MemoryStorage) RestorePost(ctx context.Context, id uint) (*models.Post, error) {
+	s.lock()
+	defer s.unlock()
+
+	post, ok := s.posts[id]
+	if !ok {
+		return nil, NotFound("post")
+	}
+	if !post.DeletedAt.Valid {
+		return nil, NewError(ErrConflict, "post is not deleted")
+	}
+
+	for _, comment := range s.comments {
+		// Сравниваем моменты, а не структуры time.Time: после чтения снимка
+		// у них может отличаться часовой пояс или монотонная часть
+		if comment.PostID == id && comment.DeletedAt.Valid && comment.DeletedAt.Time.Equal(post.DeletedAt.Time) {
+			restored := *comment
+			restored.DeletedAt = gorm.DeletedAt{}
+			s.putComment(&restored)
+		}
+	}
+	restored := *post
+	restored.DeletedAt = gorm.DeletedAt{}
+	s.putPost(&restored)
+	if err := s.commit("RestorePost"); err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
+
+func (s *MemoryStorage) RestoreComment(ctx context.Context, id uint) (*models.Comment, error) {
+	s.lock()
+	defer s.unlock()
+
+	comment, ok := s.comments[id]
+	if !ok {
+		return nil, NotFound("comment")
+	}
+
+	restored := *comment
+	switch {
+	case comment.DeletedAt.Valid:
+		restored.DeletedAt = gorm.DeletedAt{}
+		// Вместе с комментарием возвращаются надгробия родителей, убранные при его удалении:
+		// у них та же отметка. Удалённого отдельно родителя восстанавливает только он сам.
+		var parents []*models.Comment
+		for parentID := comment.ParentID; parentID != nil; {
+			parent, ok := s.comments[*parentID]
+			if !ok || !parent.DeletedAt.Valid {
+				break
+			}
+			if !parent.DeletedAt.Time.Equal(comment.DeletedAt.Time) {
+				return nil, NewError(ErrConflict, "parent comment is deleted")
+			}
+			parents = append(parents, parent)
+			parentID = parent.ParentID
+		}
+		for _, parent := range parents {
+			revived := *parent
+			revived.DeletedAt = gorm.DeletedAt{}
+			s.putComment(&revived)
+		}
+	case comment.Deleted:
+		revision, ok := s.latestRevision(models.RevisionComment, id)
+		if !ok {
+			return nil, NotFound("revision")
+		}
+		restored.Content = revision.Content
+		restored.Deleted = false
+	default:
+		return nil, NewError(ErrConflict, "comment is not deleted")
+	}
+
+	restored.UpdatedAt = s.now()
+	s.putComment(&restored)
+	s.refreshPostStats(restored.PostID)
+	if err := s.commit("RestoreComment"); err != nil {
+		return nil, err
+	}
+	return &restored, nil
+}
+
+func (s *MemoryStorage) GetPostUnscoped(ctx context.Context, id uint) (*models.Post, error) {
+	s.rlock()
+	defer s.runlock()
+
+	if post, ok := s.posts[id]; ok {
+		return post, nil
+	}
+	return nil, NotFound("post")
+}
+
+func (s *MemoryStorage) GetCommentUnscoped(ctx context.Context, id uint) (*models.Comment, error) {
+	s.rlock()
+	defer s.runlock()
+
+	if comment, ok := s.comments[id]; ok {
+		return comment, nil
+	}
+	return nil, NotFound("comment")
+}
+
+func (s *MemoryStorage) GetDeletedPosts(ctx context.Context) ([]*models.Post, error) {
+	s.rlock()
+	defer s.runlock()
+
+	posts := []*models.Post{}
+	for _, post := range s.posts {
+		if post.DeletedAt.Valid {
+			posts = append(posts, post)
+		}
+	}
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].DeletedAt.Time.After(posts[j].DeletedAt.Time)
+	})
+	return posts, nil
+}
+
+func (s *MemoryStorage) GetDeletedComments(ctx context.Context, postID uint) ([]*models.Comment, error) {
+	s.rlock()
+	defer s.runlock()
+
+	comments := []*models.Comment{}
+	for _, comment := range s.comments {
+		if comment.PostID == postID && comment.DeletedAt.Valid {
+			comments = append(comments, comment)
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool {
+		return comments[i].ID < comments[j].ID
+	})
+	return comments, nil
+}
+
+func (s *MemoryStorage) DeleteUser(ctx context.Context, id uint) error {
+	s.lock()
+	defer s.unlock()
+
+	user, ok := s.users[id]
+	if !ok || user.DeletedAt.Valid {
+		return NotFound("user")
+	}
+	removed := *user
+	removed.DeletedAt = gorm.DeletedAt{Time: s.now(), Valid: true}
+	s.putUser(&removed)
+	return s.commit("DeleteUser")
+}
+
+func (s *MemoryStorage) RestoreUser(ctx context.Context, id uint) error {
+	s.lock()
+	defer s.unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return NotFound("user")
+	}
+	if !user.DeletedAt.Valid {
+		return NewError(ErrConflict, "user is not deleted")
+	}
+	restored := *user
+	restored.DeletedAt = gorm.DeletedAt{}
+	s.putUser(&restored)
+	return s.commit("RestoreUser")
+}
+
+func (s *MemoryStorage) livePost(id uint) (*models.Post, bool) {
+	post, ok := s.posts[id]
+	if !ok || post.DeletedAt.Valid {
+		return nil, false
+	}
+	return post, true
+}
+
+func (s *MemoryStorage) livePosts() []*models.Post {
+	posts := make([]*models.Post, 0, len(s.posts))
+	for _, post := range s.posts {
+		if !post.DeletedAt.Valid {
+			posts = append(posts, post)
+		}
+	}
+	return posts
+}
+
+func (s *MemoryStorage) liveComment(id uint) (*models.Comment, bool) {
+	comment, ok := s.comments[id]
+	if !ok || comment.DeletedAt.Valid {
+		return nil, false
+	}
+	return comment, true
+}
+
+func (s *MemoryStorage) liveCommentsByIDs(ids []uint) []*models.Comment {
+	comments := make([]*models.Comment, 0, len(ids))
+	for _, id := range ids {
+		if comment, ok := s.liveComment(id); ok {
+			comments = append(comments, comment)
+		}
+	}
+	return comments
+}
+
+// indexComment добавляет новый комментарий в rootComments или replies.
+// Пост и родитель комментария не меняются, поэтому повторные версии пропускаются.
+func (s *MemoryStorage) indexComment(comment *models.Comment) {
+	if _, ok := s.comments[comment.ID]; ok {
+		return
+	}
+	if comment.ParentID == nil {
+		s.rootComments[comment.PostID] = append(s.rootComments[comment.PostID], comment.ID)
+	} else {
+		s.replies[*comment.ParentID] = append(s.replies[*comment.ParentID], comment.ID)
+	}
+}
+
+func (s *MemoryStorage) liveComments() []*models.Comment {
+	comments := make([]*models.Comment, 0, len(s.comments))
+	for _, comment := range s.comments {
+		if !comment.DeletedAt.Valid {
+			comments = append(comments, comment)
+		}
+	}
+	return comments
+}
+
+func (s *MemoryStorage) GetRevision(ctx context.Context, id uint) (*models.Revision, error) {
+	s.rlock()
+	defer s.runlock()
+
+	if revision, ok := s.revisions[id]; ok {
+		return revision, nil
+	}
+	return nil, NotFound("revision")
+}
+
+func (s *MemoryStorage) GetRevisionsByEntityIDs(ctx context.Context, entityType string, ids []uint) (map[uint][]*models.Revision, error) {
+	s.rlock()
+	defer s.runlock()
+
+	revisions := make(map[uint][]*models.Revision, len(ids))
+	for _, id := range ids {
+		revisions[id] = []*models.Revision{}
+	}
+	for _, revision := range s.revisions {
+		if revision.EntityType != entityType {
+			continue
+		}
+		if list, ok := revisions[revision.EntityID]; ok {
+			revisions[revision.EntityID] = append(list, revision)
+		}
+	}
+	for _, list := range revisions {
+		sort.Slice(list, func(i, j int) bool {
+			return list[i].ID > list[j].ID
+		})
+	}
+	return revisions, nil
+}
+
+func (s *MemoryStorage) addRevision(entityType string, entityID, authorID uint, title, content string) {
+	revision := &models.Revision{
+		ID:         s.nextID(),
+		EntityType: entityType,
+		EntityID:   entityID,
+		AuthorID:   authorID,
+		Title:      title,
+		Content:    content,
+		CreatedAt:  s.now(),
+	}
+	s.putRevision(revision)
+}
+
+func (s *MemoryStorage) latestRevision(entityType string, entityID uint) (*models.Revision, bool) {
+	var latest *models.Revision
+	for _, revision := range s.revisions {
+		if revision.EntityType == entityType && revision.EntityID == entityID {
+			if latest == nil || revision.ID > latest.ID {
+				latest = revision
+			}
+		}
+	}
+	return latest, latest != nil
+}
+
+// activity — число комментариев (или ответов), их авторов и время последнего из них
+type activity struct {
+	count        int64
+	participants int64
+	last         time.Time
+}
+
+// replyActivity считает ответы только на переданные комментарии и только для
+// сортировок по активности: при остальных ключ от ответов не зависит.
+func (s *MemoryStorage) replyActivity(order SortOrder, comments []*models.Comment) map[uint]activity {
+	if !order.byActivity() {
+		return nil
+	}
+	stats := make(map[uint]activity, len(comments))
+	for _, comment := range comments {
+		var a activity
+		authors := make(map[uint]struct{})
+		for _, reply := range s.liveCommentsByIDs(s.replies[comment.ID]) {
+			a.count++
+			authors[reply.AuthorID] = struct{}{}
+			if reply.CreatedAt.After(a.last) {
+				a.last = reply.CreatedAt
+			}
+		}
+		a.participants = int64(len(authors))
+		stats[comment.ID] = a
+	}
+	return stats
+}
+
+func (s *MemoryStorage) postsPage(posts []*models.Post, page PageArgs) *Page[*models.Post] {
+	return paginate(posts, page, func(p *models.Post) Cursor {
+		a := activity{count: int64(p.CommentCount), participants: int64(p.ParticipantCount)}
+		if p.LastCommentAt != nil {
+			a.last = *p.LastCommentAt
+		}
+		return cursorFor(page.Order, p.ID, p.CreatedAt, a)
+	})
+}
+
+// refreshPostStats пересчитывает статистику обсуждения поста после изменения его комментариев
+func (s *MemoryStorage) refreshPostStats(postID uint) {
+	if post, ok := s.posts[postID]; ok {
+		s.putPost(s.withStats(post))
+	}
+}
+
+func (s *MemoryStorage) withStats(post *models.Post) *models.Post {
+	stats := *post
+	stats.CommentCount, stats.ParticipantCount, stats.LastCommentAt = 0, 0, nil
+	authors := make(map[uint]struct{})
+	// Обход по индексам касается только комментариев этого поста
+	queue := append([]uint(nil), s.rootComments[post.ID]...)
+	for i := 0; i < len(queue); i++ {
+		queue = append(queue, s.replies[queue[i]]...)
+		comment := s.comments[queue[i]]
+		if comment.DeletedAt.Valid || comment.Deleted {
+			continue
+		}
+		stats.CommentCount++
+		authors[comment.AuthorID] = struct{}{}
+		if stats.LastCommentAt == nil || comment.CreatedAt.After(*stats.LastCommentAt) {
+			createdAt := comment.CreatedAt
+			stats.LastCommentAt = &createdAt
+		}
+	}
+	stats.ParticipantCount = len(authors)
+	return &stats
+}
+
+func (s *MemoryStorage) commentsPage(comments []*models.Comment, page PageArgs) *Page[*models.Comment] {
+	return commentsPage(comments, page, s.replyActivity(page.Order, comments))
+}
+
+func commentsPage(comments []*models.Comment, page PageArgs, stats map[uint]activity) *Page[*models.Comment] {
+	return paginate(comments, page, func(c *models.Comment) Cursor {
+		return cursorFor(page.Order, c.ID, c.CreatedAt, stats[c.ID])
+	})
+}
+
+func cursorFor(order SortOrder, id uint, createdAt time.Time, a activity) Cursor {
+	switch order {
+	case SortMostReplies:
+		return Cursor{Order: order, Key: a.count, ID: id}
+	case SortMostParticipants:
+		return Cursor{Order: order, Key: a.participants, ID: id}
+	case SortRecentlyActive:
+		last := createdAt
+		if a.last.After(last) {
+			last = a.last
+		}
+		return Cursor{Order: order, Key: last.UnixNano(), ID: id}
+	default:
+		return Cursor{Order: order, Key: createdAt.UnixNano(), ID: id}
+	}
+}
+
+// paginate упорядочивает записи по курсорам и вырезает страницу после page.After
+func paginate[T any](items []T, page PageArgs, cursor func(T) Cursor) *Page[T] {
+	type entry struct {
+		item   T
+		cursor Cursor
+	}
+
+	entries := make([]entry, 0, len(items))
+	for _, item := range items {
+		c := cursor(item)
+		if page.After != nil && !page.After.before(c) {
+			continue
+		}
+		entries = append(entries, entry{item: item, cursor: c})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].cursor.before(entries[j].cursor)
+	})
+
+	entries, hasNext := trimPage(entries, page)
+	result := &Page[T]{
+		Items:       make([]T, len(entries)),
+		Cursors:     make([]Cursor, len(entries)),
+		HasNextPage: hasNext,
+	}
+	for i, e := range entries {
+		result.Items[i] = e.item
+		result.Cursors[i] = e.cursor
 	}
-	post.UpdatedAt = time.Now()
-	s.posts[post.ID] = post
-	return nil
+	return result
 }
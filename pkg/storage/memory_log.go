@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Anabol1ks/ozon_tz/internal/models"
+)
+
+// Персистентность MemoryStorage: каждая мутация дописывает в wal.log итоговые
+// версии изменённых записей, а snapshot.json периодически сворачивает журнал.
+// При старте загружается снимок и поверх него проигрывается журнал.
+// Записи журнала — полные версии строк, поэтому повторное проигрывание безопасно.
+
+const (
+	walFileName      = "wal.log"
+	snapshotFileName = "snapshot.json"
+
+	DefaultSnapshotEvery = 1000
+)
+
+type PersistOptions struct {
+	Dir string
+	// SnapshotEvery — после скольких записей журнала он сворачивается в снимок
+	SnapshotEvery int
+}
+
+// persistedUser нужен, чтобы хеш пароля, скрытый из JSON модели, попал на диск.
+type persistedUser struct {
+	models.User
+	PasswordHash string `json:"password_hash"`
+}
+
+func persistUser(user *models.User) *persistedUser {
+	return &persistedUser{User: *user, PasswordHash: user.PasswordHash}
+}
+
+func (u *persistedUser) model() *models.User {
+	user := u.User
+	user.PasswordHash = u.PasswordHash
+	return &user
+}
+
+type walRecord struct {
+	User     *persistedUser   `json:"user,omitempty"`
+	Post     *models.Post     `json:"post,omitempty"`
+	Comment  *models.Comment  `json:"comment,omitempty"`
+	Revision *models.Revision `json:"revision,omitempty"`
+}
+
+type walEntry struct {
+	Op      string      `json:"op"`
+	LastID  uint        `json:"last_id"`
+	Records []walRecord `json:"records"`
+}
+
+type snapshot struct {
+	LastID    uint               `json:"last_id"`
+	Users     []*persistedUser   `json:"users"`
+	Posts     []*models.Post     `json:"posts"`
+	Comments  []*models.Comment  `json:"comments"`
+	Revisions []*models.Revision `json:"revisions"`
+}
+
+type memoryLog struct {
+	dir           string
+	file          *os.File
+	snapshotEvery int
+	entries       int
+	pending       []walRecord
+	ops           []string
+}
+
+// OpenMemoryStorage восстанавливает хранилище из каталога opts.Dir
+// и дальше записывает туда все изменения.
+func OpenMemoryStorage(opts PersistOptions) (*MemoryStorage, error) {
+	if opts.SnapshotEvery <= 0 {
+		opts.SnapshotEvery = DefaultSnapshotEvery
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	s := NewMemoryStorage()
+	if err := s.loadSnapshot(filepath.Join(opts.Dir, snapshotFileName)); err != nil {
+		return nil, err
+	}
+	walPath := filepath.Join(opts.Dir, walFileName)
+	replayed, err := s.replayLog(walPath)
+	if err != nil {
+		return nil, err
+	}
+
+	s.fillPaths()
+	s.fillPostStats()
+
+	file, err := os.OpenFile(walPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s.journal = &memoryLog{
+		dir:           opts.Dir,
+		file:          file,
+		snapshotEvery: opts.SnapshotEvery,
+		entries:       replayed,
+	}
+	if replayed >= opts.SnapshotEvery {
+		if err := s.writeSnapshot(); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Close сворачивает журнал в снимок и закрывает файл.
+func (s *MemoryStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+	err := s.writeSnapshot()
+	if closeErr := s.journal.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.journal = nil
+	return err
+}
+
+func (s *MemoryStorage) putUser(user *models.User) {
+	s.users[user.ID] = user
+	s.record(walRecord{User: persistUser(user)})
+}
+
+func (s *MemoryStorage) putPost(post *models.Post) {
+	s.posts[post.ID] = post
+	s.record(walRecord{Post: post})
+}
+
+func (s *MemoryStorage) putComment(comment *models.Comment) {
+	s.indexComment(comment)
+	s.comments[comment.ID] = comment
+	s.record(walRecord{Comment: comment})
+}
+
+func (s *MemoryStorage) putRevision(revision *models.Revision) {
+	s.revisions[revision.ID] = revision
+	s.record(walRecord{Revision: revision})
+}
+
+func (s *MemoryStorage) record(r walRecord) {
+	if s.journal != nil {
+		s.journal.pending = append(s.journal.pending, r)
+	}
+}
+
+// commit записывает накопленные мутацией изменения одной строкой журнала.
+// Внутри WithTx запись откладывается до конца транзакции.
+// Вызывается под s.mu. Если запись не удалась, изменение остаётся в памяти,
+// но может не пережить перезапуск.
+func (s *MemoryStorage) commit(op string) error {
+	if s.journal == nil {
+		return nil
+	}
+	s.journal.ops = append(s.journal.ops, op)
+	if s.inTx {
+		return nil
+	}
+	return s.flush()
+}
+
+func (s *MemoryStorage) flush() error {
+	if s.journal == nil {
+		return nil
+	}
+	ops, records := s.journal.ops, s.journal.pending
+	s.journal.ops, s.journal.pending = nil, nil
+	if len(records) == 0 {
+		return nil
+	}
+
+	entry := walEntry{Op: strings.Join(ops, ","), LastID: s.lastID, Records: records}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := s.journal.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write wal: %w", err)
+	}
+	if err := s.journal.file.Sync(); err != nil {
+		return fmt.Errorf("sync wal: %w", err)
+	}
+
+	s.journal.entries++
+	if s.journal.entries >= s.journal.snapshotEvery {
+		return s.writeSnapshot()
+	}
+	return nil
+}
+
+func (s *MemoryStorage) apply(r walRecord) {
+	switch {
+	case r.User != nil:
+		s.users[r.User.ID] = r.User.model()
+	case r.Post != nil:
+		s.posts[r.Post.ID] = r.Post
+	case r.Comment != nil:
+		s.indexComment(r.Comment)
+		s.comments[r.Comment.ID] = r.Comment
+	case r.Revision != nil:
+		s.revisions[r.Revision.ID] = r.Revision
+	}
+}
+
+func (s *MemoryStorage) loadSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	s.lastID = snap.LastID
+	for _, user := range snap.Users {
+		s.apply(walRecord{User: user})
+	}
+	for _, post := range snap.Posts {
+		s.apply(walRecord{Post: post})
+	}
+	for _, comment := range snap.Comments {
+		s.apply(walRecord{Comment: comment})
+	}
+	for _, revision := range snap.Revisions {
+		s.apply(walRecord{Revision: revision})
+	}
+	return nil
+}
+
+// fillPaths достраивает Path и Depth комментариев, сохранённых до их появления.
+// Родитель старше ответа, поэтому при обходе по возрастанию id его путь уже готов.
+// Исправленные записи попадут на диск со следующим снимком.
+func (s *MemoryStorage) fillPaths() {
+	var ids []uint
+	for id, comment := range s.comments {
+		if comment.ParentID != nil && comment.Depth == 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	for _, id := range ids {
+		comment := s.comments[id]
+		if parent, ok := s.comments[*comment.ParentID]; ok {
+			filled := *comment
+			placeUnder(&filled, parent)
+			s.comments[id] = &filled
+		}
+	}
+}
+
+// fillPostStats пересчитывает статистику постов: в журналах, записанных
+// до её появления, она нулевая.
+func (s *MemoryStorage) fillPostStats() {
+	for id, post := range s.posts {
+		s.posts[id] = s.withStats(post)
+	}
+}
+
+// replayLog проигрывает журнал и возвращает число записей в нём.
+// Недописанная последняя строка (падение посреди записи) отбрасывается.
+func (s *MemoryStorage) replayLog(path string) (int, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var entries int
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(bytes.TrimSpace(line)) > 0 {
+				log.Printf("wal: отброшена недописанная запись в %s", path)
+				return entries, os.Truncate(path, offset)
+			}
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+
+		var entry walEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return entries, fmt.Errorf("read wal entry %d: %w", entries+1, err)
+		}
+		for _, r := range entry.Records {
+			s.apply(r)
+		}
+		if entry.LastID > s.lastID {
+			s.lastID = entry.LastID
+		}
+		entries++
+		offset += int64(len(line))
+	}
+}
+
+// writeSnapshot сохраняет всё состояние во временный файл, атомарно подменяет
+// им снимок и очищает журнал. Вызывается под s.mu.
+func (s *MemoryStorage) writeSnapshot() error {
+	snap := snapshot{LastID: s.lastID}
+	for _, user := range s.users {
+		snap.Users = append(snap.Users, persistUser(user))
+	}
+	for _, post := range s.posts {
+		snap.Posts = append(snap.Posts, post)
+	}
+	for _, comment := range s.comments {
+		snap.Comments = append(snap.Comments, comment)
+	}
+	for _, revision := range s.revisions {
+		snap.Revisions = append(snap.Revisions, revision)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.journal.dir, snapshotFileName)
+	tmp, err := os.CreateTemp(s.journal.dir, snapshotFileName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	if dir, err := os.Open(s.journal.dir); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	// Если упасть до очистки, журнал проиграется поверх снимка и даст то же состояние.
+	if err := s.journal.file.Truncate(0); err != nil {
+		return err
+	}
+	s.journal.entries = 0
+	return nil
+}
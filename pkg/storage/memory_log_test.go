@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStoragePersistence(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := OpenMemoryStorage(PersistOptions{Dir: dir, SnapshotEvery: 4})
+	require.NoError(t, err)
+
+	user := &models.User{Username: "alice", PasswordHash: "hash"}
+	require.NoError(t, s.CreateUser(ctx, user))
+	post := &models.Post{Title: "Пост", Content: "Текст", AuthorID: user.ID}
+	require.NoError(t, s.CreatePost(ctx, post))
+	parent := &models.Comment{PostID: post.ID, AuthorID: user.ID, Content: "Первый"}
+	require.NoError(t, s.CreateComment(ctx, parent))
+	reply := &models.Comment{PostID: post.ID, AuthorID: user.ID, ParentID: &parent.ID, Content: "Ответ"}
+	require.NoError(t, s.CreateComment(ctx, reply))
+
+	// Четвёртая запись свернула журнал в снимок, дальше снова пишется журнал
+	_, err = os.Stat(filepath.Join(dir, snapshotFileName))
+	require.NoError(t, err)
+
+	edited := *post
+	edited.Title = "Новый заголовок"
+	require.NoError(t, s.UpdatePost(ctx, &edited))
+	_, err = s.DeleteComment(ctx, parent.ID)
+	require.NoError(t, err)
+
+	// Без Close, как при падении процесса
+	restored, err := OpenMemoryStorage(PersistOptions{Dir: dir, SnapshotEvery: 4})
+	require.NoError(t, err)
+	defer restored.Close()
+
+	gotUser, err := restored.GetUserByUsername(ctx, "alice")
+	require.NoError(t, err)
+	assert.Equal(t, "hash", gotUser.PasswordHash)
+
+	gotPost, err := restored.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Новый заголовок", gotPost.Title)
+
+	revisions, err := restored.GetRevisionsByEntityIDs(ctx, models.RevisionPost, []uint{post.ID})
+	require.NoError(t, err)
+	require.Len(t, revisions[post.ID], 1)
+	assert.Equal(t, "Пост", revisions[post.ID][0].Title)
+
+	tombstone, err := restored.GetComment(ctx, parent.ID)
+	require.NoError(t, err)
+	assert.True(t, tombstone.Deleted)
+
+	next := &models.Post{Title: "Ещё", Content: "Текст", AuthorID: user.ID}
+	require.NoError(t, restored.CreatePost(ctx, next))
+	assert.Greater(t, next.ID, revisions[post.ID][0].ID, "id не должны повторяться после рестарта")
+}
+
+func TestMemoryStorageTornWAL(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	s, err := OpenMemoryStorage(PersistOptions{Dir: dir})
+	require.NoError(t, err)
+	require.NoError(t, s.CreateUser(ctx, &models.User{Username: "bob"}))
+
+	walPath := filepath.Join(dir, walFileName)
+	f, err := os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"op":"CreateUser","last_id":2,"records":[{"us`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	restored, err := OpenMemoryStorage(PersistOptions{Dir: dir})
+	require.NoError(t, err)
+	defer restored.Close()
+
+	_, err = restored.GetUserByUsername(ctx, "bob")
+	assert.NoError(t, err)
+	require.NoError(t, restored.CreateUser(ctx, &models.User{Username: "carol"}))
+
+	again, err := OpenMemoryStorage(PersistOptions{Dir: dir})
+	require.NoError(t, err)
+	_, err = again.GetUserByUsername(ctx, "carol")
+	assert.NoError(t, err, "после обрезки хвоста журнал продолжает читаться")
+}
+
+func TestMemoryStorageFillsLegacyPaths(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Журнал, записанный до появления path, depth и статистики постов
+	wal := `{"op":"CreatePost","last_id":1,"records":[{"post":{"id":1,"title":"p","content":"t","author_id":1}}]}
+{"op":"CreateComment","last_id":1,"records":[{"comment":{"id":1,"post_id":1,"author_id":1,"content":"a"}}]}
+{"op":"CreateComment","last_id":2,"records":[{"comment":{"id":2,"post_id":1,"author_id":1,"parent_id":1,"content":"b"}}]}
+{"op":"CreateComment","last_id":3,"records":[{"comment":{"id":3,"post_id":1,"author_id":1,"parent_id":2,"content":"c"}}]}
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, walFileName), []byte(wal), 0o644))
+
+	s, err := OpenMemoryStorage(PersistOptions{Dir: dir})
+	require.NoError(t, err)
+	defer s.Close()
+
+	comment, err := s.GetComment(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, 2, comment.Depth)
+	assert.Equal(t, "1/2/", comment.Path)
+
+	post, err := s.GetPost(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, post.CommentCount)
+	assert.Equal(t, 1, post.ParticipantCount)
+}
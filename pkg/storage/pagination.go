@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = NewError(ErrValidation, "invalid cursor")
+
+type SortOrder string
+
+const (
+	SortNewest           SortOrder = "newest"
+	SortOldest           SortOrder = "oldest"
+	SortMostReplies      SortOrder = "most_replies"
+	SortMostParticipants SortOrder = "most_participants"
+	SortRecentlyActive   SortOrder = "recently_active"
+)
+
+// desc сообщает, сортируется ли выборка по убыванию ключа.
+// Во всех порядках, кроме oldest, первыми идут "самые" записи.
+func (o SortOrder) desc() bool {
+	return o != SortOldest
+}
+
+// byActivity сообщает, зависит ли ключ сортировки от ответов на запись.
+func (o SortOrder) byActivity() bool {
+	return o == SortMostReplies || o == SortMostParticipants || o == SortRecentlyActive
+}
+
+// Cursor указывает на позицию в выборке: значение ключа сортировки
+// (время в наносекундах или число ответов) и id записи для разрешения равенств.
+type Cursor struct {
+	Order SortOrder
+	Key   int64
+	ID    uint
+}
+
+// PageArgs описывает страницу keyset-пагинации: First записей строго после After.
+// First <= 0 означает выборку без ограничения.
+type PageArgs struct {
+	First int
+	After *Cursor
+	// AfterID задаёт начало страницы id последнего показанного ответа вместо
+	// курсора; ответ может быть уже мягко удалён. Учитывается только в GetRepliesPage.
+	AfterID uint
+	Order   SortOrder
+}
+
+type Page[T any] struct {
+	Items       []T
+	Cursors     []Cursor
+	HasNextPage bool
+}
+
+func (c Cursor) Encode() string {
+	raw := fmt.Sprintf("%s:%d:%d", c.Order, c.Key, c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	c := Cursor{Order: SortOrder(parts[0])}
+	if _, err := fmt.Sscanf(parts[1], "%d:%d", &c.Key, &c.ID); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// before сообщает, идёт ли позиция c раньше other в порядке сортировки.
+func (c Cursor) before(other Cursor) bool {
+	if c.Key == other.Key {
+		if c.Order.desc() {
+			return c.ID > other.ID
+		}
+		return c.ID < other.ID
+	}
+	if c.Order.desc() {
+		return c.Key > other.Key
+	}
+	return c.Key < other.Key
+}
+
+// keyTime переводит ключ курсора обратно во время для порядков по дате.
+func (c Cursor) keyTime() time.Time {
+	return time.Unix(0, c.Key)
+}
+
+// trimPage отрезает лишнюю запись, запрошенную для определения hasNextPage.
+func trimPage[T any](items []T, page PageArgs) ([]T, bool) {
+	if page.First > 0 && len(items) > page.First {
+		return items[:page.First], true
+	}
+	return items, false
+}
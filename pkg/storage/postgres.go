@@ -1,73 +1,944 @@
 package storage
 
 import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/Anabol1ks/ozon_tz/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// PostgresStorage хранит данные через GORM. Запросы переносимы,
+// поэтому то же хранилище используется и для STORAGE_TYPE=sqlite.
 type PostgresStorage struct {
-	db *gorm.DB
+	db   *gorm.DB
+	opts PostgresOptions
+	// inTx — хранилище поверх открытой транзакции WithTx
+	inTx bool
+}
+
+type PostgresOptions struct {
+	// QueryTimeout ограничивает время одного вызова хранилища; 0 — без ограничения
+	QueryTimeout time.Duration
+}
+
+func NewPostgresStorage(db *gorm.DB, opts PostgresOptions) *PostgresStorage {
+	return &PostgresStorage{db: db, opts: opts}
 }
 
-func NewPostgresStorage(db *gorm.DB) *PostgresStorage {
-	return &PostgresStorage{db: db}
+// conn привязывает запросы к контексту вызова: отменённый GraphQL-запрос
+// или закрытая подписка прерывают и запрос к базе.
+func (s *PostgresStorage) conn(ctx context.Context) (*gorm.DB, context.CancelFunc) {
+	cancel := context.CancelFunc(func() {})
+	if s.opts.QueryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.opts.QueryTimeout)
+	}
+	return s.db.WithContext(ctx), cancel
 }
 
-func (s *PostgresStorage) CreateUser(user *models.User) error {
-	return s.db.Create(user).Error
+func (s *PostgresStorage) WithTx(ctx context.Context, fn func(tx Storage) error) error {
+	if s.inTx {
+		return fn(s)
+	}
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(&PostgresStorage{db: tx, opts: s.opts, inTx: true})
+	})
 }
 
-func (s *PostgresStorage) GetUser(id uint) (*models.User, error) {
+// forUpdate блокирует прочитанные в транзакции строки до её конца.
+// SQLite пишет по одному соединению и FOR UPDATE не поддерживает.
+func (s *PostgresStorage) forUpdate(db *gorm.DB) *gorm.DB {
+	if s.inTx && db.Dialector.Name() == "postgres" {
+		return db.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+	return db
+}
+
+func (s *PostgresStorage) CreateUser(ctx context.Context, user *models.User) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	return translateError("user", db.Create(user).Error)
+}
+
+func (s *PostgresStorage) GetUser(ctx context.Context, id uint) (*models.User, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
 	var user models.User
-	err := s.db.First(&user, id).Error
-	return &user, err
+	if err := db.First(&user, id).Error; err != nil {
+		return nil, translateError("user", err)
+	}
+	return &user, nil
 }
 
-func (s *PostgresStorage) CreatePost(post *models.Post) error {
-	return s.db.Create(post).Error
+func (s *PostgresStorage) GetUsersByIDs(ctx context.Context, ids []uint) ([]*models.User, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var users []*models.User
+	err := db.Unscoped().Where("id IN ?", ids).Find(&users).Error
+	return users, err
+}
+
+func (s *PostgresStorage) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return nil, translateError("user", err)
+	}
+	return &user, nil
+}
+
+func (s *PostgresStorage) CreatePost(ctx context.Context, post *models.Post) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	return db.Create(post).Error
 }
 
-func (s *PostgresStorage) GetPost(id uint) (*models.Post, error) {
+func (s *PostgresStorage) GetPost(ctx context.Context, id uint) (*models.Post, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
 	var post models.Post
-	err := s.db.First(&post, id).Error
-	return &post, err
+	if err := s.forUpdate(db).First(&post, id).Error; err != nil {
+		return nil, translateError("post", err)
+	}
+	return &post, nil
 }
 
-func (s *PostgresStorage) GetPosts() ([]*models.Post, error) {
+func (s *PostgresStorage) GetPostsByIDs(ctx context.Context, ids []uint) ([]*models.Post, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
 	var posts []*models.Post
-	err := s.db.Find(&posts).Error
+	err := db.Where("id IN ?", ids).Find(&posts).Error
 	return posts, err
 }
 
-func (s *PostgresStorage) CreateComment(comment *models.Comment) error {
-	return s.db.Create(comment).Error
+func (s *PostgresStorage) GetPosts(ctx context.Context, order SortOrder) ([]*models.Post, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	page, err := s.findPosts(db, PageArgs{Order: order})
+	if err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
+
+func (s *PostgresStorage) GetPostsPage(ctx context.Context, page PageArgs) (*Page[*models.Post], error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	return s.findPosts(db, page)
+}
+
+func (s *PostgresStorage) CreateComment(ctx context.Context, comment *models.Comment) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	comment.Path, comment.Depth = "", 0
+	if comment.ParentID != nil {
+		var parent models.Comment
+		if err := db.Unscoped().Select("id", "path", "depth").First(&parent, *comment.ParentID).Error; err != nil {
+			return translateError("comment", err)
+		}
+		placeUnder(comment, &parent)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := lockPost(tx, comment.PostID); err != nil {
+			return err
+		}
+		if err := tx.Create(comment).Error; err != nil {
+			return err
+		}
+		return refreshPostStats(tx, comment.PostID)
+	})
 }
 
-func (s *PostgresStorage) GetComment(id uint) (*models.Comment, error) {
+func (s *PostgresStorage) GetComment(ctx context.Context, id uint) (*models.Comment, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
 	var comment models.Comment
-	err := s.db.First(&comment, id).Error
-	return &comment, err
+	if err := s.forUpdate(db).First(&comment, id).Error; err != nil {
+		return nil, translateError("comment", err)
+	}
+	return &comment, nil
 }
 
-func (s *PostgresStorage) GetComments(postID uint, limit, offset *int32) ([]*models.Comment, error) {
+func (s *PostgresStorage) GetCommentsByIDs(ctx context.Context, ids []uint) ([]*models.Comment, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
 	var comments []*models.Comment
-	query := s.db.Where("post_id = ? AND parent_id IS NULL", postID)
+	err := db.Where("id IN ?", ids).Find(&comments).Error
+	return comments, err
+}
+
+func (s *PostgresStorage) GetComments(ctx context.Context, postID uint, limit, offset *int32, order SortOrder) ([]*models.Comment, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	query := db.Where("comments.post_id = ? AND comments.parent_id IS NULL", postID)
 	if limit != nil {
 		query = query.Limit(int(*limit))
 	}
 	if offset != nil {
 		query = query.Offset(int(*offset))
 	}
-	err := query.Find(&comments).Error
+	page, err := s.findComments(query, PageArgs{Order: order})
+	if err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
+
+func (s *PostgresStorage) GetCommentsPage(ctx context.Context, postID uint, page PageArgs) (*Page[*models.Comment], error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	query := db.Where("comments.post_id = ? AND comments.parent_id IS NULL", postID)
+	return s.findComments(query, page)
+}
+
+func (s *PostgresStorage) GetRepliesPage(ctx context.Context, parentID uint, page PageArgs) (*Page[*models.Comment], error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	if page.AfterID != 0 {
+		key := commentSortKey(page.Order)
+		// Последний показанный ответ мог быть удалён, пока клиент листал ветку
+		var after commentRow
+		err := db.Unscoped().Table("comments").Select("comments.*, "+key.expr+" AS "+key.column()).
+			Where("comments.id = ? AND comments.parent_id = ?", page.AfterID, parentID).
+			Take(&after).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidCursor
+		}
+		if err != nil {
+			return nil, err
+		}
+		cursor := key.cursor(page.Order, after.ID, after.SortCount, after.SortTime.Time)
+		page.After = &cursor
+	}
+
+	query := db.Where("comments.parent_id = ?", parentID)
+	return s.findComments(query, page)
+}
+
+// GetReplyCounts считает всё одним запросом: потомки находятся по префиксу пути.
+func (s *PostgresStorage) GetReplyCounts(ctx context.Context, ids []uint) (map[uint]ReplyCounts, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var rows []struct {
+		ID          uint
+		Replies     int
+		Descendants int
+	}
+	err := db.Raw(`SELECT a.id,
+			COUNT(CASE WHEN d.parent_id = a.id THEN 1 END) AS replies,
+			COUNT(*) AS descendants
+		FROM comments a
+		JOIN comments d ON d.post_id = a.post_id
+			AND d.path LIKE a.path || CAST(a.id AS TEXT) || '/%'
+			AND d.deleted_at IS NULL
+		WHERE a.id IN ?
+		GROUP BY a.id`, ids).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[uint]ReplyCounts, len(ids))
+	for _, row := range rows {
+		counts[row.ID] = ReplyCounts{Replies: row.Replies, Descendants: row.Descendants}
+	}
+	return counts, nil
+}
+
+func (s *PostgresStorage) GetCommentChildren(ctx context.Context, parentID uint, order SortOrder) ([]*models.Comment, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	page, err := s.findComments(db.Where("comments.parent_id = ?", parentID), PageArgs{Order: order})
+	if err != nil {
+		return nil, err
+	}
+	return page.Items, nil
+}
+
+// GetChildrenByParentIDs нумерует ответы внутри каждого родителя оконной функцией,
+// чтобы ограничение действовало на каждого родителя, а не на всю выборку.
+func (s *PostgresStorage) GetChildrenByParentIDs(ctx context.Context, parentIDs []uint, order SortOrder, limit int) (map[uint][]*models.Comment, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	key := commentSortKey(order)
+	dir := "ASC"
+	if order.desc() {
+		dir = "DESC"
+	}
+	ranked := db.Model(&models.Comment{}).
+		Select(fmt.Sprintf("comments.*, ROW_NUMBER() OVER (PARTITION BY comments.parent_id ORDER BY %s %s, comments.id %s) AS reply_rank", key.expr, dir, dir)).
+		Where("comments.parent_id IN ?", parentIDs)
+	query := db.Table("(?) AS comments", ranked)
+	if limit > 0 {
+		query = query.Where("reply_rank <= ?", limit)
+	}
+
+	var replies []*models.Comment
+	if err := query.Order("reply_rank ASC").Find(&replies).Error; err != nil {
+		return nil, err
+	}
+
+	children := make(map[uint][]*models.Comment, len(parentIDs))
+	for _, id := range parentIDs {
+		children[id] = []*models.Comment{}
+	}
+	for _, comment := range replies {
+		children[*comment.ParentID] = append(children[*comment.ParentID], comment)
+	}
+	return children, nil
+}
+
+func (s *PostgresStorage) GetCommentsAfter(ctx context.Context, postID, afterID uint) ([]*models.Comment, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var comments []*models.Comment
+	err := db.Where("post_id = ? AND id > ?", postID, afterID).Order("id ASC").Find(&comments).Error
 	return comments, err
 }
 
-func (s *PostgresStorage) GetCommentChildren(parentID uint) ([]*models.Comment, error) {
+// GetCommentDescendants ищет потомков по префиксу материализованного пути.
+func (s *PostgresStorage) GetCommentDescendants(ctx context.Context, id uint, limit int) ([]*models.Comment, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var comment models.Comment
+	if err := db.First(&comment, id).Error; err != nil {
+		return nil, translateError("comment", err)
+	}
+
+	query := db.Where("post_id = ? AND path LIKE ?", comment.PostID, descendantPrefix(&comment)+"%").
+		Order("created_at ASC, id ASC")
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	descendants := []*models.Comment{}
+	err := query.Find(&descendants).Error
+	return descendants, err
+}
+
+// GetCommentTree загружает ветку одним рекурсивным запросом, порядок обхода
+// и пути собираются уже в памяти.
+func (s *PostgresStorage) GetCommentTree(ctx context.Context, postID uint, rootLimit, maxDepth int) ([]*ThreadComment, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	roots := "SELECT * FROM comments WHERE post_id = ? AND parent_id IS NULL AND deleted_at IS NULL ORDER BY created_at, id"
+	args := []any{postID}
+	if rootLimit > 0 {
+		roots += " LIMIT ?"
+		args = append(args, rootLimit)
+	}
+	// Уровень в ветке называется tree_depth: comments.depth уже есть среди c.*,
+	// и в Postgres ссылка t.depth была бы неоднозначной
+	depthLimit := ""
+	if maxDepth > 0 {
+		depthLimit = " AND t.tree_depth + 1 < ?"
+		args = append(args, maxDepth)
+	}
+	query := `WITH RECURSIVE thread AS (
+		SELECT roots.*, 0 AS tree_depth FROM (` + roots + `) roots
+		UNION ALL
+		SELECT c.*, t.tree_depth + 1 FROM comments c
+		JOIN thread t ON c.parent_id = t.id
+		WHERE c.deleted_at IS NULL` + depthLimit + `
+	)
+	SELECT * FROM thread`
+
 	var comments []*models.Comment
-	err := s.db.Where("parent_id = ?", parentID).Find(&comments).Error
+	if err := db.Raw(query, args...).Scan(&comments).Error; err != nil {
+		return nil, err
+	}
+
+	var top []*models.Comment
+	children := make(map[uint][]*models.Comment)
+	for _, comment := range comments {
+		if comment.ParentID == nil {
+			top = append(top, comment)
+		} else {
+			children[*comment.ParentID] = append(children[*comment.ParentID], comment)
+		}
+	}
+	return flattenThread(top, rootLimit, maxDepth, func(parentID uint) []*models.Comment {
+		return children[parentID]
+	}), nil
+}
+
+func (s *PostgresStorage) UpdatePost(ctx context.Context, post *models.Post) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var old models.Post
+		if err := tx.First(&old, post.ID).Error; err != nil {
+			return translateError("post", err)
+		}
+		if old.Title != post.Title || old.Content != post.Content {
+			if err := addRevision(tx, models.RevisionPost, old.ID, old.AuthorID, old.Title, old.Content); err != nil {
+				return err
+			}
+		}
+		// Статистику ведёт само хранилище, пост мог быть прочитан до нового комментария
+		post.CommentCount, post.ParticipantCount, post.LastCommentAt = old.CommentCount, old.ParticipantCount, old.LastCommentAt
+		err := tx.Model(post).Select("*").Omit(postStatsColumns...).Updates(post).Error
+		return translateError("post", err)
+	})
+}
+
+func (s *PostgresStorage) UpdateComment(ctx context.Context, comment *models.Comment) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var old models.Comment
+		if err := tx.First(&old, comment.ID).Error; err != nil {
+			return translateError("comment", err)
+		}
+		if old.Content != comment.Content {
+			if err := addRevision(tx, models.RevisionComment, old.ID, old.AuthorID, "", old.Content); err != nil {
+				return err
+			}
+		}
+		return translateError("comment", tx.Model(comment).Select("*").Updates(comment).Error)
+	})
+}
+
+func (s *PostgresStorage) DeleteComment(ctx context.Context, id uint) (*models.Comment, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var tombstone *models.Comment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var comment models.Comment
+		if err := tx.First(&comment, id).Error; err != nil {
+			return translateError("comment", err)
+		}
+		if err := lockPost(tx, comment.PostID); err != nil {
+			return err
+		}
+
+		replies, err := countReplies(tx, id)
+		if err != nil {
+			return err
+		}
+		if replies > 0 {
+			if err := addRevision(tx, models.RevisionComment, comment.ID, comment.AuthorID, "", comment.Content); err != nil {
+				return err
+			}
+			comment.Content = DeletedContent
+			comment.Deleted = true
+			if err := tx.Model(&comment).Select("content", "deleted", "updated_at").Updates(&comment).Error; err != nil {
+				return err
+			}
+			tombstone = &comment
+			return refreshPostStats(tx, comment.PostID)
+		}
+		if err := removeComment(tx, &comment); err != nil {
+			return err
+		}
+		return refreshPostStats(tx, comment.PostID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tombstone, nil
+}
+
+var postStatsColumns = []string{"comment_count", "participant_count", "last_comment_at"}
+
+// lockPost блокирует пост до конца транзакции. Транзакции, меняющие комментарии,
+// берут блокировки в одном порядке: сначала пост, потом комментарии, иначе
+// встречные транзакции ждут друг друга.
+func lockPost(tx *gorm.DB, postID uint) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return tx.Exec("SELECT id FROM posts WHERE id = ? FOR UPDATE", postID).Error
+}
+
+// refreshPostStats пересчитывает статистику обсуждения поста по видимым комментариям.
+// Сначала пост блокируется: пересчёт идёт отдельным запросом после блокировки и
+// в READ COMMITTED видит комментарии транзакций, закоммиченных, пока она ждала.
+func refreshPostStats(tx *gorm.DB, postID uint) error {
+	if err := lockPost(tx, postID); err != nil {
+		return err
+	}
+	const visible = "c.post_id = posts.id AND c.deleted_at IS NULL AND NOT c.deleted"
+	return tx.Exec(`UPDATE posts SET
+		comment_count = (SELECT COUNT(*) FROM comments c WHERE `+visible+`),
+		participant_count = (SELECT COUNT(DISTINCT c.author_id) FROM comments c WHERE `+visible+`),
+		last_comment_at = (SELECT MAX(c.created_at) FROM comments c WHERE `+visible+`)
+		WHERE id = ?`, postID).Error
+}
+
+func countReplies(tx *gorm.DB, id uint) (int64, error) {
+	var count int64
+	err := tx.Model(&models.Comment{}).Where("parent_id = ?", id).Count(&count).Error
+	return count, err
+}
+
+// removeComment мягко удаляет комментарий и поднимается вверх по ветке,
+// убирая надгробия родителей, у которых не осталось ответов.
+// Все убранные записи получают одну отметку, по ней RestoreComment их и вернёт.
+func removeComment(tx *gorm.DB, comment *models.Comment) error {
+	deletedAt := deletionTime(time.Time{})
+	for {
+		if err := tx.Model(&models.Comment{}).Where("id = ?", comment.ID).Update("deleted_at", deletedAt).Error; err != nil {
+			return err
+		}
+		if comment.ParentID == nil {
+			return nil
+		}
+
+		var parent models.Comment
+		if err := tx.First(&parent, *comment.ParentID).Error; err != nil {
+			return translateError("comment", err)
+		}
+		if !parent.Deleted {
+			return nil
+		}
+		replies, err := countReplies(tx, parent.ID)
+		if err != nil || replies > 0 {
+			return err
+		}
+		comment = &parent
+	}
+}
+
+func (s *PostgresStorage) DeletePost(ctx context.Context, id uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var post models.Post
+		if err := tx.First(&post, id).Error; err != nil {
+			return translateError("post", err)
+		}
+
+		// Комментарии получают ту же отметку, что и пост, чтобы RestorePost
+		// вернул ровно их, не трогая удалённые раньше по отдельности.
+		var latest sortTime
+		err := tx.Unscoped().Model(&models.Comment{}).Where("post_id = ?", id).
+			Select("MAX(deleted_at)").Row().Scan(&latest)
+		if err != nil {
+			return err
+		}
+		deletedAt := deletionTime(latest.Time)
+		if err := tx.Model(&models.Comment{}).Where("post_id = ?", id).Update("deleted_at", deletedAt).Error; err != nil {
+			return err
+		}
+		return tx.Model(&post).Update("deleted_at", deletedAt).Error
+	})
+}
+
+func (s *PostgresStorage) RestorePost(ctx context.Context, id uint) (*models.Post, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var post models.Post
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().First(&post, id).Error; err != nil {
+			return translateError("post", err)
+		}
+		if !post.DeletedAt.Valid {
+			return NewError(ErrConflict, "post is not deleted")
+		}
+
+		err := tx.Unscoped().Model(&models.Comment{}).
+			Where("post_id = ? AND deleted_at = ?", id, post.DeletedAt.Time).
+			Update("deleted_at", nil).Error
+		if err != nil {
+			return err
+		}
+		post.DeletedAt = gorm.DeletedAt{}
+		return tx.Unscoped().Model(&post).Update("deleted_at", nil).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+func (s *PostgresStorage) RestoreComment(ctx context.Context, id uint) (*models.Comment, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var comment models.Comment
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().First(&comment, id).Error; err != nil {
+			return translateError("comment", err)
+		}
+		if err := lockPost(tx, comment.PostID); err != nil {
+			return err
+		}
+
+		switch {
+		case comment.DeletedAt.Valid:
+			// Вместе с комментарием возвращаются надгробия родителей, убранные при его удалении:
+			// у них та же отметка. Удалённого отдельно родителя восстанавливает только он сам.
+			ids := []uint{comment.ID}
+			for parentID := comment.ParentID; parentID != nil; {
+				var parent models.Comment
+				err := tx.Unscoped().First(&parent, *parentID).Error
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					break
+				}
+				if err != nil {
+					return err
+				}
+				if !parent.DeletedAt.Valid {
+					break
+				}
+				if !parent.DeletedAt.Time.Equal(comment.DeletedAt.Time) {
+					return NewError(ErrConflict, "parent comment is deleted")
+				}
+				ids = append(ids, parent.ID)
+				parentID = parent.ParentID
+			}
+			comment.DeletedAt = gorm.DeletedAt{}
+			if err := tx.Unscoped().Model(&models.Comment{}).Where("id IN ?", ids).Update("deleted_at", nil).Error; err != nil {
+				return err
+			}
+		case comment.Deleted:
+			var revision models.Revision
+			err := tx.Where("entity_type = ? AND entity_id = ?", models.RevisionComment, id).Order("id DESC").First(&revision).Error
+			if err != nil {
+				return translateError("revision", err)
+			}
+			comment.Content = revision.Content
+			comment.Deleted = false
+			if err := tx.Model(&comment).Select("content", "deleted", "updated_at").Updates(&comment).Error; err != nil {
+				return err
+			}
+		default:
+			return NewError(ErrConflict, "comment is not deleted")
+		}
+		return refreshPostStats(tx, comment.PostID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+func (s *PostgresStorage) GetPostUnscoped(ctx context.Context, id uint) (*models.Post, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var post models.Post
+	if err := s.forUpdate(db).Unscoped().First(&post, id).Error; err != nil {
+		return nil, translateError("post", err)
+	}
+	return &post, nil
+}
+
+func (s *PostgresStorage) GetCommentUnscoped(ctx context.Context, id uint) (*models.Comment, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var comment models.Comment
+	if err := s.forUpdate(db).Unscoped().First(&comment, id).Error; err != nil {
+		return nil, translateError("comment", err)
+	}
+	return &comment, nil
+}
+
+func (s *PostgresStorage) GetDeletedPosts(ctx context.Context) ([]*models.Post, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var posts []*models.Post
+	err := db.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&posts).Error
+	return posts, err
+}
+
+func (s *PostgresStorage) GetDeletedComments(ctx context.Context, postID uint) ([]*models.Comment, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var comments []*models.Comment
+	err := db.Unscoped().Where("post_id = ? AND deleted_at IS NOT NULL", postID).Order("id ASC").Find(&comments).Error
 	return comments, err
 }
 
-func (s *PostgresStorage) UpdatePost(post *models.Post) error {
-	return s.db.Save(post).Error
+func (s *PostgresStorage) DeleteUser(ctx context.Context, id uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	result := db.Delete(&models.User{}, id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return NotFound("user")
+	}
+	return nil
+}
+
+func (s *PostgresStorage) RestoreUser(ctx context.Context, id uint) error {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var user models.User
+	if err := db.Unscoped().First(&user, id).Error; err != nil {
+		return translateError("user", err)
+	}
+	if !user.DeletedAt.Valid {
+		return NewError(ErrConflict, "user is not deleted")
+	}
+	return db.Unscoped().Model(&user).Update("deleted_at", nil).Error
+}
+
+func (s *PostgresStorage) GetRevision(ctx context.Context, id uint) (*models.Revision, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var revision models.Revision
+	if err := db.First(&revision, id).Error; err != nil {
+		return nil, translateError("revision", err)
+	}
+	return &revision, nil
+}
+
+func (s *PostgresStorage) GetRevisionsByEntityIDs(ctx context.Context, entityType string, ids []uint) (map[uint][]*models.Revision, error) {
+	db, cancel := s.conn(ctx)
+	defer cancel()
+
+	var list []*models.Revision
+	err := db.Where("entity_type = ? AND entity_id IN ?", entityType, ids).Order("id DESC").Find(&list).Error
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := make(map[uint][]*models.Revision, len(ids))
+	for _, id := range ids {
+		revisions[id] = []*models.Revision{}
+	}
+	for _, revision := range list {
+		revisions[revision.EntityID] = append(revisions[revision.EntityID], revision)
+	}
+	return revisions, nil
+}
+
+func addRevision(tx *gorm.DB, entityType string, entityID, authorID uint, title, content string) error {
+	return tx.Create(&models.Revision{
+		EntityType: entityType,
+		EntityID:   entityID,
+		AuthorID:   authorID,
+		Title:      title,
+		Content:    content,
+	}).Error
+}
+
+type postRow struct {
+	models.Post
+	SortCount int64
+	SortTime  sortTime
+}
+
+type commentRow struct {
+	models.Comment
+	SortCount int64
+	SortTime  sortTime
+}
+
+// deletionTime возвращает текущее время, но строго позже latest:
+// по отметке удаления поста RestorePost отличает его комментарии
+// от удалённых раньше по отдельности.
+func deletionTime(latest time.Time) time.Time {
+	t := time.Now().Truncate(time.Microsecond)
+	if !t.After(latest) {
+		t = latest.Add(time.Microsecond)
+	}
+	return t
+}
+
+// sqliteTimeFormats — форматы, в которых драйвер SQLite пишет время.
+var sqliteTimeFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02T15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+}
+
+// sortTime читает ключ сортировки по дате. Вычисляемые колонки вроде
+// MAX(created_at) SQLite отдаёт строкой, а не временем.
+type sortTime struct {
+	time.Time
+}
+
+func (t *sortTime) Scan(value any) error {
+	switch v := value.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v
+		return nil
+	case []byte:
+		return t.parse(string(v))
+	case string:
+		return t.parse(v)
+	default:
+		return fmt.Errorf("unsupported sort time %T", value)
+	}
+}
+
+func (t sortTime) Value() (driver.Value, error) {
+	return t.Time, nil
+}
+
+func (t *sortTime) parse(s string) error {
+	for _, layout := range sqliteTimeFormats {
+		if parsed, err := time.Parse(layout, s); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported sort time %q", s)
+}
+
+// sortKey — SQL-выражение, по которому упорядочивается выборка.
+// Ключи по дате выбираются в колонку sort_time, счётчики — в sort_count.
+type sortKey struct {
+	expr   string
+	byTime bool
+}
+
+func postSortKey(order SortOrder) sortKey {
+	switch order {
+	case SortMostReplies:
+		return sortKey{expr: "posts.comment_count"}
+	case SortMostParticipants:
+		return sortKey{expr: "posts.participant_count"}
+	case SortRecentlyActive:
+		return sortKey{expr: "COALESCE(posts.last_comment_at, posts.created_at)", byTime: true}
+	default:
+		return sortKey{expr: "posts.created_at", byTime: true}
+	}
+}
+
+func commentSortKey(order SortOrder) sortKey {
+	switch order {
+	case SortMostReplies:
+		return sortKey{expr: "(SELECT COUNT(*) FROM comments r WHERE r.parent_id = comments.id AND r.deleted_at IS NULL)"}
+	case SortMostParticipants:
+		return sortKey{expr: "(SELECT COUNT(DISTINCT r.author_id) FROM comments r WHERE r.parent_id = comments.id AND r.deleted_at IS NULL)"}
+	case SortRecentlyActive:
+		return sortKey{
+			expr:   "COALESCE((SELECT MAX(r.created_at) FROM comments r WHERE r.parent_id = comments.id AND r.deleted_at IS NULL), comments.created_at)",
+			byTime: true,
+		}
+	default:
+		return sortKey{expr: "comments.created_at", byTime: true}
+	}
+}
+
+func (k sortKey) column() string {
+	if k.byTime {
+		return "sort_time"
+	}
+	return "sort_count"
+}
+
+func (k sortKey) value(c Cursor) any {
+	if k.byTime {
+		return c.keyTime()
+	}
+	return c.Key
+}
+
+func (k sortKey) cursor(order SortOrder, id uint, count int64, t time.Time) Cursor {
+	if k.byTime {
+		return Cursor{Order: order, Key: t.UnixNano(), ID: id}
+	}
+	return Cursor{Order: order, Key: count, ID: id}
+}
+
+func (s *PostgresStorage) findPosts(query *gorm.DB, page PageArgs) (*Page[*models.Post], error) {
+	key := postSortKey(page.Order)
+
+	var rows []postRow
+	if err := keysetPage(query.Table("posts"), "posts", key, page).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	rows, hasNext := trimPage(rows, page)
+	result := &Page[*models.Post]{
+		Items:       make([]*models.Post, len(rows)),
+		Cursors:     make([]Cursor, len(rows)),
+		HasNextPage: hasNext,
+	}
+	for i := range rows {
+		result.Items[i] = &rows[i].Post
+		result.Cursors[i] = key.cursor(page.Order, rows[i].ID, rows[i].SortCount, rows[i].SortTime.Time)
+	}
+	return result, nil
+}
+
+func (s *PostgresStorage) findComments(query *gorm.DB, page PageArgs) (*Page[*models.Comment], error) {
+	key := commentSortKey(page.Order)
+
+	var rows []commentRow
+	if err := keysetPage(query.Table("comments"), "comments", key, page).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	rows, hasNext := trimPage(rows, page)
+	result := &Page[*models.Comment]{
+		Items:       make([]*models.Comment, len(rows)),
+		Cursors:     make([]Cursor, len(rows)),
+		HasNextPage: hasNext,
+	}
+	for i := range rows {
+		result.Items[i] = &rows[i].Comment
+		result.Cursors[i] = key.cursor(page.Order, rows[i].ID, rows[i].SortCount, rows[i].SortTime.Time)
+	}
+	return result, nil
+}
+
+// keysetPage добавляет к запросу ключ сортировки, условие "после курсора" и порядок.
+// Запрашивается на одну запись больше, чтобы узнать, есть ли следующая страница.
+func keysetPage(query *gorm.DB, table string, key sortKey, page PageArgs) *gorm.DB {
+	query = query.Select(table + ".*, " + key.expr + " AS " + key.column())
+
+	op, dir := ">", "ASC"
+	if page.Order.desc() {
+		op, dir = "<", "DESC"
+	}
+
+	if page.After != nil {
+		value := key.value(*page.After)
+		query = query.Where(
+			fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND %[3]s.id %[2]s ?))", key.expr, op, table),
+			value, value, page.After.ID,
+		)
+	}
+	query = query.Order(key.expr + " " + dir).Order(table + ".id " + dir)
+	if page.First > 0 {
+		query = query.Limit(page.First + 1)
+	}
+	return query
 }
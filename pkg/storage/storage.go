@@ -1,18 +1,86 @@
 package storage
 
 import (
+	"context"
+
 	"github.com/Anabol1ks/ozon_tz/internal/models"
 )
 
+// DeletedContent заменяет текст удалённого комментария, у которого остались ответы
+const DeletedContent = "[deleted]"
+
+// ReplyCounts — число прямых ответов на комментарий и всех его потомков.
+type ReplyCounts struct {
+	Replies     int
+	Descendants int
+}
+
+// Storage не отдаёт мягко удалённые записи, если в названии метода не сказано иное.
+// Исключение — GetUsersByIDs: авторы нужны и для оставшихся записей удалённых пользователей.
+// Запросы к базе выполняются в контексте вызова и прерываются вместе с ним;
+// MemoryStorage отвечает сразу и контекст не проверяет.
 type Storage interface {
-	CreateUser(*models.User) error
-	GetUser(id uint) (*models.User, error)
-	CreatePost(*models.Post) error
-	GetPost(id uint) (*models.Post, error)
-	GetPosts() ([]*models.Post, error)
-	CreateComment(*models.Comment) error
-	GetComment(id uint) (*models.Comment, error)
-	GetComments(postID uint, limit, offset *int32) ([]*models.Comment, error)
-	GetCommentChildren(parentID uint) ([]*models.Comment, error)
-	UpdatePost(*models.Post) error
+	CreateUser(ctx context.Context, user *models.User) error
+	GetUser(ctx context.Context, id uint) (*models.User, error)
+	GetUsersByIDs(ctx context.Context, ids []uint) ([]*models.User, error)
+	GetUserByUsername(ctx context.Context, username string) (*models.User, error)
+	CreatePost(ctx context.Context, post *models.Post) error
+	GetPost(ctx context.Context, id uint) (*models.Post, error)
+	GetPostsByIDs(ctx context.Context, ids []uint) ([]*models.Post, error)
+	GetPosts(ctx context.Context, order SortOrder) ([]*models.Post, error)
+	GetPostsPage(ctx context.Context, page PageArgs) (*Page[*models.Post], error)
+	CreateComment(ctx context.Context, comment *models.Comment) error
+	GetComment(ctx context.Context, id uint) (*models.Comment, error)
+	GetCommentsByIDs(ctx context.Context, ids []uint) ([]*models.Comment, error)
+	GetComments(ctx context.Context, postID uint, limit, offset *int32, order SortOrder) ([]*models.Comment, error)
+	GetCommentsPage(ctx context.Context, postID uint, page PageArgs) (*Page[*models.Comment], error)
+	// GetRepliesPage возвращает ErrInvalidCursor, если page.AfterID — не ответ на parentID.
+	// Мягко удалённый ответ на parentID остаётся допустимым page.AfterID.
+	GetRepliesPage(ctx context.Context, parentID uint, page PageArgs) (*Page[*models.Comment], error)
+	// GetReplyCounts считает ответы и всех потомков комментариев, мягко удалённые
+	// не учитываются. У отсутствующих комментариев счётчики нулевые.
+	GetReplyCounts(ctx context.Context, ids []uint) (map[uint]ReplyCounts, error)
+	GetCommentChildren(ctx context.Context, parentID uint, order SortOrder) ([]*models.Comment, error)
+	// GetChildrenByParentIDs возвращает первые limit ответов на каждый из комментариев;
+	// нулевое ограничение ничего не ограничивает.
+	GetChildrenByParentIDs(ctx context.Context, parentIDs []uint, order SortOrder, limit int) (map[uint][]*models.Comment, error)
+	// GetCommentTree возвращает ветку поста плоским списком в порядке обхода в глубину:
+	// первые rootLimit корневых комментариев (от старых к новым) и ответы
+	// не глубже maxDepth уровней. Нулевые ограничения ничего не ограничивают.
+	GetCommentTree(ctx context.Context, postID uint, rootLimit, maxDepth int) ([]*ThreadComment, error)
+	// GetCommentDescendants возвращает всех потомков комментария от старых к новым,
+	// не больше limit (0 — без ограничения)
+	GetCommentDescendants(ctx context.Context, id uint, limit int) ([]*models.Comment, error)
+	// GetCommentsAfter возвращает все комментарии поста (с ответами) с id больше afterID по возрастанию id.
+	// CreateComment выдаёт id, держа блокировку поста, поэтому комментарии одного поста
+	// фиксируются в порядке id и более поздняя выборка не найдёт новых id меньше уже виденных.
+	GetCommentsAfter(ctx context.Context, postID, afterID uint) ([]*models.Comment, error)
+	// UpdatePost и UpdateComment сохраняют прежний текст в ревизию, если он изменился
+	UpdatePost(ctx context.Context, post *models.Post) error
+	UpdateComment(ctx context.Context, comment *models.Comment) error
+	// DeleteComment мягко удаляет комментарий без ответов и возвращает nil. Комментарий
+	// с ответами остаётся в дереве с текстом DeletedContent и возвращается,
+	// а прежний текст попадает в ревизию.
+	DeleteComment(ctx context.Context, id uint) (*models.Comment, error)
+	// RestoreComment отменяет DeleteComment: снимает мягкое удаление (вместе
+	// с убранными надгробиями родителей) или возвращает надгробию прежний текст.
+	RestoreComment(ctx context.Context, id uint) (*models.Comment, error)
+	// DeletePost мягко удаляет пост и все его комментарии
+	DeletePost(ctx context.Context, id uint) error
+	// RestorePost возвращает пост и комментарии, удалённые вместе с ним
+	RestorePost(ctx context.Context, id uint) (*models.Post, error)
+	DeleteUser(ctx context.Context, id uint) error
+	RestoreUser(ctx context.Context, id uint) error
+	// GetPostUnscoped и GetCommentUnscoped находят запись, даже если она мягко удалена
+	GetPostUnscoped(ctx context.Context, id uint) (*models.Post, error)
+	GetCommentUnscoped(ctx context.Context, id uint) (*models.Comment, error)
+	GetDeletedPosts(ctx context.Context) ([]*models.Post, error)
+	GetDeletedComments(ctx context.Context, postID uint) ([]*models.Comment, error)
+	GetRevision(ctx context.Context, id uint) (*models.Revision, error)
+	// GetRevisionsByEntityIDs возвращает ревизии сущностей от новых к старым
+	GetRevisionsByEntityIDs(ctx context.Context, entityType string, ids []uint) (map[uint][]*models.Revision, error)
+	// WithTx выполняет fn как одну транзакцию: проверки и запись внутри fn
+	// не перемежаются с конкурентными мутациями. Ошибка fn возвращается как есть.
+	// Вложенный вызов на tx выполняет fn в той же транзакции.
+	WithTx(ctx context.Context, fn func(tx Storage) error) error
 }
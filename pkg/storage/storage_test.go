@@ -0,0 +1,88 @@
+package storage_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Anabol1ks/ozon_tz/pkg/migrate"
+	"github.com/Anabol1ks/ozon_tz/pkg/storage"
+	"github.com/Anabol1ks/ozon_tz/pkg/storage/storagetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewMemoryStorage()
+	})
+}
+
+func TestPersistentMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		s, err := storage.OpenMemoryStorage(storage.PersistOptions{Dir: t.TempDir(), SnapshotEvery: 7})
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return storage.NewPostgresStorage(openSQLite(t), storage.PostgresOptions{})
+	})
+}
+
+func TestQueryContext(t *testing.T) {
+	db := openSQLite(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := storage.NewPostgresStorage(db, storage.PostgresOptions{}).GetPosts(ctx, storage.SortNewest)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	timed := storage.NewPostgresStorage(db, storage.PostgresOptions{QueryTimeout: time.Nanosecond})
+	_, err = timed.GetPosts(context.Background(), storage.SortNewest)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// TestPostgresStorage запускается, только если задана TEST_DATABASE_URL.
+// Таблицы базы очищаются перед каждым подтестом.
+func TestPostgresStorage(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL не задана")
+	}
+	db, err := storage.OpenPostgres(dsn)
+	require.NoError(t, err)
+	migrateUp(t, db)
+
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		require.NoError(t, db.Exec("TRUNCATE users, posts, comments, revisions RESTART IDENTITY").Error)
+		return storage.NewPostgresStorage(db, storage.PostgresOptions{})
+	})
+}
+
+func openSQLite(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := storage.OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	migrateUp(t, db)
+	return db
+}
+
+func migrateUp(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	m, err := migrate.New(db)
+	require.NoError(t, err)
+	_, err = m.Up()
+	require.NoError(t, err)
+}
@@ -0,0 +1,846 @@
+// Package storagetest — общий набор проверок, которые должна проходить
+// любая реализация storage.Storage.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Anabol1ks/ozon_tz/internal/models"
+	"github.com/Anabol1ks/ozon_tz/pkg/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Factory возвращает пустое хранилище для одного подтеста.
+type Factory func(t *testing.T) storage.Storage
+
+var base = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func at(minutes int) time.Time {
+	return base.Add(time.Duration(minutes) * time.Minute)
+}
+
+func Run(t *testing.T, newStore Factory) {
+	tests := []struct {
+		name string
+		run  func(*testing.T, storage.Storage)
+	}{
+		{"Users", testUsers},
+		{"Posts", testPosts},
+		{"Comments", testComments},
+		{"CommentTree", testCommentTree},
+		{"CommentPaths", testCommentPaths},
+		{"ReplyCounts", testReplyCounts},
+		{"PostStats", testPostStats},
+		{"PostOrdering", testPostOrdering},
+		{"CommentOrdering", testCommentOrdering},
+		{"Pagination", testPagination},
+		{"DeleteComment", testDeleteComment},
+		{"DeletePost", testDeletePost},
+		{"Revisions", testRevisions},
+		{"Concurrency", testConcurrency},
+		{"Transactions", testTransactions},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStore(t))
+		})
+	}
+}
+
+func testUsers(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	alice := &models.User{Username: "alice", PasswordHash: "hash"}
+	require.NoError(t, s.CreateUser(ctx, alice))
+	assert.NotZero(t, alice.ID)
+	assert.False(t, alice.CreatedAt.IsZero())
+
+	assert.ErrorIs(t, s.CreateUser(ctx, &models.User{Username: "alice"}), storage.ErrConflict)
+
+	got, err := s.GetUser(ctx, alice.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "alice", got.Username)
+	assert.Equal(t, "hash", got.PasswordHash)
+
+	_, err = s.GetUser(ctx, alice.ID+1000)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.GetUserByUsername(ctx, "nobody")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.NoError(t, s.DeleteUser(ctx, alice.ID))
+	_, err = s.GetUser(ctx, alice.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.GetUserByUsername(ctx, "alice")
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.ErrorIs(t, s.DeleteUser(ctx, alice.ID), storage.ErrNotFound)
+
+	users, err := s.GetUsersByIDs(ctx, []uint{alice.ID, alice.ID + 1000})
+	require.NoError(t, err)
+	require.Len(t, users, 1, "GetUsersByIDs отдаёт и удалённых")
+
+	require.NoError(t, s.RestoreUser(ctx, alice.ID))
+	assert.ErrorIs(t, s.RestoreUser(ctx, alice.ID), storage.ErrConflict)
+	assert.ErrorIs(t, s.RestoreUser(ctx, alice.ID+1000), storage.ErrNotFound)
+	_, err = s.GetUserByUsername(ctx, "alice")
+	assert.NoError(t, err)
+}
+
+func testPosts(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	post := newPost(t, s, author.ID, at(0))
+	assert.NotZero(t, post.ID)
+
+	got, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, post.Title, got.Title)
+	assert.Equal(t, author.ID, got.AuthorID)
+	assert.True(t, at(0).Equal(got.CreatedAt), "переданное время создания сохраняется")
+
+	_, err = s.GetPost(ctx, post.ID+1000)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	posts, err := s.GetPostsByIDs(ctx, []uint{post.ID, post.ID + 1000})
+	require.NoError(t, err)
+	assert.Len(t, posts, 1)
+
+	edited := *got
+	edited.DisableComments = true
+	depth := 3
+	edited.MaxReplyDepth = &depth
+	require.NoError(t, s.UpdatePost(ctx, &edited))
+	got, err = s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.True(t, got.DisableComments)
+	require.NotNil(t, got.MaxReplyDepth)
+	assert.Equal(t, 3, *got.MaxReplyDepth)
+
+	missing := edited
+	missing.ID = post.ID + 1000
+	assert.ErrorIs(t, s.UpdatePost(ctx, &missing), storage.ErrNotFound)
+}
+
+func testComments(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	post := newPost(t, s, author.ID, at(0))
+	c1 := newComment(t, s, post.ID, author.ID, nil, at(1))
+	c2 := newComment(t, s, post.ID, author.ID, &c1.ID, at(2))
+	c3 := newComment(t, s, post.ID, author.ID, &c2.ID, at(3))
+	c4 := newComment(t, s, post.ID, author.ID, nil, at(4))
+
+	got, err := s.GetComment(ctx, c2.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got.ParentID)
+	assert.Equal(t, c1.ID, *got.ParentID)
+
+	_, err = s.GetComment(ctx, c4.ID+1000)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	comments, err := s.GetCommentsByIDs(ctx, []uint{c1.ID, c3.ID, c4.ID + 1000})
+	require.NoError(t, err)
+	assert.Len(t, comments, 2)
+
+	roots, err := s.GetComments(ctx, post.ID, nil, nil, storage.SortOldest)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{c1.ID, c4.ID}, ids(roots))
+
+	limit, offset := int32(1), int32(1)
+	roots, err = s.GetComments(ctx, post.ID, &limit, &offset, storage.SortOldest)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{c4.ID}, ids(roots))
+
+	roots, err = s.GetComments(ctx, post.ID, nil, &offset, storage.SortOldest)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{c4.ID}, ids(roots))
+
+	far := int32(10)
+	roots, err = s.GetComments(ctx, post.ID, nil, &far, storage.SortOldest)
+	require.NoError(t, err)
+	assert.Empty(t, roots)
+
+	children, err := s.GetCommentChildren(ctx, c1.ID, storage.SortOldest)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{c2.ID}, ids(children))
+
+	byParent, err := s.GetChildrenByParentIDs(ctx, []uint{c1.ID, c2.ID, c4.ID}, storage.SortOldest, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{c2.ID}, ids(byParent[c1.ID]))
+	assert.Equal(t, []uint{c3.ID}, ids(byParent[c2.ID]))
+	assert.NotNil(t, byParent[c4.ID], "у комментария без ответов пустой список, а не nil")
+	assert.Empty(t, byParent[c4.ID])
+
+	after, err := s.GetCommentsAfter(ctx, post.ID, c1.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{c2.ID, c3.ID, c4.ID}, ids(after))
+
+	replies, err := s.GetRepliesPage(ctx, c2.ID, storage.PageArgs{Order: storage.SortOldest})
+	require.NoError(t, err)
+	assert.Equal(t, []uint{c3.ID}, ids(replies.Items))
+
+	// Ограничение действует на ответы каждого родителя отдельно
+	r1 := newComment(t, s, post.ID, author.ID, &c4.ID, at(5))
+	r2 := newComment(t, s, post.ID, author.ID, &c4.ID, at(6))
+	byParent, err = s.GetChildrenByParentIDs(ctx, []uint{c1.ID, c4.ID}, storage.SortNewest, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{c2.ID}, ids(byParent[c1.ID]))
+	assert.Equal(t, []uint{r2.ID}, ids(byParent[c4.ID]))
+	byParent, err = s.GetChildrenByParentIDs(ctx, []uint{c4.ID}, storage.SortOldest, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{r1.ID, r2.ID}, ids(byParent[c4.ID]))
+}
+
+func testCommentTree(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	post := newPost(t, s, author.ID, at(0))
+	other := newPost(t, s, author.ID, at(0))
+
+	// a1 создан позже a2, но получил меньший id: порядок задаёт время
+	a := newComment(t, s, post.ID, author.ID, nil, at(1))
+	a1 := newComment(t, s, post.ID, author.ID, &a.ID, at(5))
+	a2 := newComment(t, s, post.ID, author.ID, &a.ID, at(3))
+	a2x := newComment(t, s, post.ID, author.ID, &a2.ID, at(4))
+	b := newComment(t, s, post.ID, author.ID, nil, at(2))
+	c := newComment(t, s, post.ID, author.ID, nil, at(6))
+	gone := newComment(t, s, post.ID, author.ID, &c.ID, at(7))
+	newComment(t, s, other.ID, author.ID, nil, at(1))
+
+	_, err := s.DeleteComment(ctx, gone.ID)
+	require.NoError(t, err)
+
+	thread, err := s.GetCommentTree(ctx, post.ID, 0, 0)
+	require.NoError(t, err)
+	var got []uint
+	for _, item := range thread {
+		got = append(got, item.Comment.ID)
+	}
+	assert.Equal(t, []uint{a.ID, a2.ID, a2x.ID, a1.ID, b.ID, c.ID}, got)
+	assert.Equal(t, 2, thread[2].Depth)
+	assert.Equal(t, []uint{a.ID, a2.ID, a2x.ID}, thread[2].Path)
+	assert.Equal(t, 0, thread[4].Depth)
+	assert.Equal(t, []uint{b.ID}, thread[4].Path)
+
+	thread, err = s.GetCommentTree(ctx, post.ID, 1, 2)
+	require.NoError(t, err)
+	got = nil
+	for _, item := range thread {
+		got = append(got, item.Comment.ID)
+	}
+	assert.Equal(t, []uint{a.ID, a2.ID, a1.ID}, got)
+
+	thread, err = s.GetCommentTree(ctx, post.ID+1000, 0, 0)
+	require.NoError(t, err)
+	assert.Empty(t, thread)
+}
+
+func testCommentPaths(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	post := newPost(t, s, author.ID, at(0))
+	root := newComment(t, s, post.ID, author.ID, nil, at(1))
+	child := newComment(t, s, post.ID, author.ID, &root.ID, at(2))
+	grandchild := newComment(t, s, post.ID, author.ID, &child.ID, at(3))
+	sibling := newComment(t, s, post.ID, author.ID, &root.ID, at(4))
+	gone := newComment(t, s, post.ID, author.ID, &sibling.ID, at(5))
+	other := newComment(t, s, post.ID, author.ID, nil, at(6))
+	newComment(t, s, post.ID, author.ID, &other.ID, at(7))
+
+	assert.Equal(t, 0, root.Depth)
+	assert.Empty(t, root.Path)
+	got, err := s.GetComment(ctx, grandchild.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, got.Depth)
+	assert.Equal(t, []uint{root.ID, child.ID}, storage.AncestorIDs(got.Path))
+
+	_, err = s.DeleteComment(ctx, gone.ID)
+	require.NoError(t, err)
+
+	descendants, err := s.GetCommentDescendants(ctx, root.ID, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{child.ID, grandchild.ID, sibling.ID}, ids(descendants))
+
+	descendants, err = s.GetCommentDescendants(ctx, root.ID, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{child.ID, grandchild.ID}, ids(descendants))
+
+	descendants, err = s.GetCommentDescendants(ctx, grandchild.ID, 0)
+	require.NoError(t, err)
+	assert.Empty(t, descendants)
+
+	_, err = s.GetCommentDescendants(ctx, gone.ID, 0)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	flattened := &models.Comment{PostID: post.ID, AuthorID: author.ID, ParentID: &root.ID, ReplyToID: &grandchild.ID, Content: "x"}
+	require.NoError(t, s.CreateComment(ctx, flattened))
+	got, err = s.GetComment(ctx, flattened.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got.ReplyToID)
+	assert.Equal(t, grandchild.ID, *got.ReplyToID)
+	assert.Equal(t, 1, got.Depth)
+
+	missing := other.ID + 1000
+	err = s.CreateComment(ctx, &models.Comment{PostID: post.ID, AuthorID: author.ID, ParentID: &missing, Content: "x"})
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testReplyCounts(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	post := newPost(t, s, author.ID, at(0))
+	root := newComment(t, s, post.ID, author.ID, nil, at(1))
+	r1 := newComment(t, s, post.ID, author.ID, &root.ID, at(2))
+	r2 := newComment(t, s, post.ID, author.ID, &root.ID, at(3))
+	r3 := newComment(t, s, post.ID, author.ID, &root.ID, at(4))
+	nested := newComment(t, s, post.ID, author.ID, &r1.ID, at(5))
+	gone := newComment(t, s, post.ID, author.ID, &r2.ID, at(6))
+
+	_, err := s.DeleteComment(ctx, gone.ID)
+	require.NoError(t, err)
+
+	counts, err := s.GetReplyCounts(ctx, []uint{root.ID, r1.ID, r2.ID, nested.ID + 1000})
+	require.NoError(t, err)
+	assert.Equal(t, storage.ReplyCounts{Replies: 3, Descendants: 4}, counts[root.ID])
+	assert.Equal(t, storage.ReplyCounts{Replies: 1, Descendants: 1}, counts[r1.ID])
+	assert.Equal(t, storage.ReplyCounts{}, counts[r2.ID])
+	assert.Equal(t, storage.ReplyCounts{}, counts[nested.ID+1000])
+
+	page, err := s.GetRepliesPage(ctx, root.ID, storage.PageArgs{First: 1, AfterID: r1.ID, Order: storage.SortOldest})
+	require.NoError(t, err)
+	assert.Equal(t, []uint{r2.ID}, ids(page.Items))
+	assert.True(t, page.HasNextPage)
+
+	page, err = s.GetRepliesPage(ctx, root.ID, storage.PageArgs{AfterID: r3.ID, Order: storage.SortNewest})
+	require.NoError(t, err)
+	assert.Equal(t, []uint{r2.ID, r1.ID}, ids(page.Items))
+
+	_, err = s.GetRepliesPage(ctx, root.ID, storage.PageArgs{AfterID: nested.ID, Order: storage.SortOldest})
+	assert.ErrorIs(t, err, storage.ErrInvalidCursor)
+
+	// Удалённый после показа ответ по-прежнему задаёт начало следующей страницы
+	_, err = s.DeleteComment(ctx, r2.ID)
+	require.NoError(t, err)
+	page, err = s.GetRepliesPage(ctx, root.ID, storage.PageArgs{AfterID: r2.ID, Order: storage.SortOldest})
+	require.NoError(t, err)
+	assert.Equal(t, []uint{r3.ID}, ids(page.Items))
+}
+
+func testPostStats(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	reader := newUser(t, s, "reader")
+	post := newPost(t, s, author.ID, at(0))
+	quiet := newPost(t, s, author.ID, at(1))
+
+	assertStats := func(id uint, comments, participants int, last time.Time) {
+		t.Helper()
+		got, err := s.GetPost(ctx, id)
+		require.NoError(t, err)
+		assert.Equal(t, comments, got.CommentCount)
+		assert.Equal(t, participants, got.ParticipantCount)
+		if last.IsZero() {
+			assert.Nil(t, got.LastCommentAt)
+			return
+		}
+		require.NotNil(t, got.LastCommentAt)
+		assert.True(t, last.Equal(*got.LastCommentAt), "%v != %v", last, *got.LastCommentAt)
+	}
+	assertStats(post.ID, 0, 0, time.Time{})
+
+	root := newComment(t, s, post.ID, author.ID, nil, at(2))
+	newComment(t, s, post.ID, reader.ID, &root.ID, at(3))
+	reply := newComment(t, s, post.ID, reader.ID, &root.ID, at(4))
+	assertStats(post.ID, 3, 2, at(4))
+	assertStats(quiet.ID, 0, 0, time.Time{})
+
+	// Пост, прочитанный до комментариев, не затирает статистику при сохранении
+	post.Title = "Новый заголовок"
+	require.NoError(t, s.UpdatePost(ctx, post))
+	assertStats(post.ID, 3, 2, at(4))
+
+	// Надгробие в статистику не входит
+	_, err := s.DeleteComment(ctx, root.ID)
+	require.NoError(t, err)
+	assertStats(post.ID, 2, 1, at(4))
+
+	_, err = s.DeleteComment(ctx, reply.ID)
+	require.NoError(t, err)
+	assertStats(post.ID, 1, 1, at(3))
+
+	_, err = s.RestoreComment(ctx, reply.ID)
+	require.NoError(t, err)
+	assertStats(post.ID, 2, 1, at(4))
+
+	_, err = s.RestoreComment(ctx, root.ID)
+	require.NoError(t, err)
+	assertStats(post.ID, 3, 2, at(4))
+
+	for i := 0; i < 4; i++ {
+		newComment(t, s, quiet.ID, author.ID, nil, at(5+i))
+	}
+	cases := map[storage.SortOrder][]uint{
+		storage.SortMostReplies:      {quiet.ID, post.ID},
+		storage.SortMostParticipants: {post.ID, quiet.ID},
+		storage.SortRecentlyActive:   {quiet.ID, post.ID},
+	}
+	for order, want := range cases {
+		posts, err := s.GetPosts(ctx, order)
+		require.NoError(t, err, order)
+		assert.Equal(t, want, ids(posts), order)
+	}
+}
+
+func testPostOrdering(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	p1 := newPost(t, s, author.ID, at(0))
+	p2 := newPost(t, s, author.ID, at(1))
+	p3 := newPost(t, s, author.ID, at(2))
+	reader := newUser(t, s, "reader")
+	newComment(t, s, p1.ID, author.ID, nil, at(5))
+	newComment(t, s, p1.ID, reader.ID, nil, at(6))
+	newComment(t, s, p2.ID, author.ID, nil, at(3))
+
+	cases := map[storage.SortOrder][]uint{
+		storage.SortNewest:           {p3.ID, p2.ID, p1.ID},
+		storage.SortOldest:           {p1.ID, p2.ID, p3.ID},
+		storage.SortMostReplies:      {p1.ID, p2.ID, p3.ID},
+		storage.SortMostParticipants: {p1.ID, p2.ID, p3.ID},
+		storage.SortRecentlyActive:   {p1.ID, p2.ID, p3.ID},
+	}
+	for order, want := range cases {
+		posts, err := s.GetPosts(ctx, order)
+		require.NoError(t, err, order)
+		assert.Equal(t, want, ids(posts), order)
+	}
+
+	// При равном ключе порядок определяет id
+	p4 := newPost(t, s, author.ID, at(2))
+	posts, err := s.GetPosts(ctx, storage.SortNewest)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{p4.ID, p3.ID, p2.ID, p1.ID}, ids(posts))
+	posts, err = s.GetPosts(ctx, storage.SortOldest)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{p1.ID, p2.ID, p3.ID, p4.ID}, ids(posts))
+}
+
+func testCommentOrdering(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	post := newPost(t, s, author.ID, at(0))
+	r1 := newComment(t, s, post.ID, author.ID, nil, at(0))
+	r2 := newComment(t, s, post.ID, author.ID, nil, at(1))
+	r3 := newComment(t, s, post.ID, author.ID, nil, at(2))
+	newComment(t, s, post.ID, author.ID, &r1.ID, at(3))
+	newComment(t, s, post.ID, author.ID, &r1.ID, at(4))
+	newComment(t, s, post.ID, author.ID, &r3.ID, at(10))
+
+	cases := map[storage.SortOrder][]uint{
+		storage.SortNewest:      {r3.ID, r2.ID, r1.ID},
+		storage.SortOldest:      {r1.ID, r2.ID, r3.ID},
+		storage.SortMostReplies: {r1.ID, r3.ID, r2.ID},
+		// У r1 и r3 по одному автору ответов, при равенстве первым идёт больший id
+		storage.SortMostParticipants: {r3.ID, r1.ID, r2.ID},
+		storage.SortRecentlyActive:   {r3.ID, r1.ID, r2.ID},
+	}
+	for order, want := range cases {
+		comments, err := s.GetComments(ctx, post.ID, nil, nil, order)
+		require.NoError(t, err, order)
+		assert.Equal(t, want, ids(comments), order)
+	}
+}
+
+func testPagination(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	var posts []*models.Post
+	for i := 0; i < 5; i++ {
+		// Пары постов с одинаковым временем проверяют переход страницы внутри равных ключей
+		posts = append(posts, newPost(t, s, author.ID, at(i/2)))
+	}
+	for i, post := range posts {
+		for j := 0; j < i%3; j++ {
+			newComment(t, s, post.ID, author.ID, nil, at(10+i))
+		}
+	}
+
+	for _, order := range []storage.SortOrder{storage.SortNewest, storage.SortOldest, storage.SortMostReplies, storage.SortRecentlyActive} {
+		all, err := s.GetPosts(ctx, order)
+		require.NoError(t, err)
+
+		var walked []uint
+		page := storage.PageArgs{First: 2, Order: order}
+		for {
+			result, err := s.GetPostsPage(ctx, page)
+			require.NoError(t, err, order)
+			require.Len(t, result.Cursors, len(result.Items))
+			walked = append(walked, ids(result.Items)...)
+			if !result.HasNextPage {
+				break
+			}
+			require.NotEmpty(t, result.Items)
+			page.After = &result.Cursors[len(result.Cursors)-1]
+
+			decoded, err := storage.DecodeCursor(page.After.Encode())
+			require.NoError(t, err)
+			page.After = &decoded
+		}
+		assert.Equal(t, ids(all), walked, order)
+	}
+}
+
+func testDeleteComment(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	post := newPost(t, s, author.ID, at(0))
+	parent := newComment(t, s, post.ID, author.ID, nil, at(1))
+	reply := newComment(t, s, post.ID, author.ID, &parent.ID, at(2))
+
+	tombstone, err := s.DeleteComment(ctx, parent.ID)
+	require.NoError(t, err)
+	require.NotNil(t, tombstone, "комментарий с ответами становится надгробием")
+	assert.True(t, tombstone.Deleted)
+	assert.Equal(t, storage.DeletedContent, tombstone.Content)
+
+	got, err := s.GetComment(ctx, parent.ID)
+	require.NoError(t, err)
+	assert.True(t, got.Deleted)
+	assert.True(t, got.UpdatedAt.After(parent.UpdatedAt), "надгробие меняет время правки")
+
+	removed, err := s.DeleteComment(ctx, reply.ID)
+	require.NoError(t, err)
+	assert.Nil(t, removed)
+	for _, id := range []uint{reply.ID, parent.ID} {
+		_, err = s.GetComment(ctx, id)
+		assert.ErrorIs(t, err, storage.ErrNotFound, "надгробие без ответов убирается вместе с последним ответом")
+	}
+	_, err = s.DeleteComment(ctx, reply.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	edited := *reply
+	edited.Content = "правка"
+	assert.ErrorIs(t, s.UpdateComment(ctx, &edited), storage.ErrNotFound)
+
+	deleted, err := s.GetDeletedComments(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{parent.ID, reply.ID}, ids(deleted))
+
+	unscoped, err := s.GetCommentUnscoped(ctx, reply.ID)
+	require.NoError(t, err)
+	assert.True(t, unscoped.DeletedAt.Valid)
+
+	restored, err := s.RestoreComment(ctx, reply.ID)
+	require.NoError(t, err)
+	assert.False(t, restored.DeletedAt.Valid)
+	got, err = s.GetComment(ctx, parent.ID)
+	require.NoError(t, err, "надгробие родителя возвращается вместе с ответом")
+	assert.True(t, got.Deleted)
+
+	restored, err = s.RestoreComment(ctx, parent.ID)
+	require.NoError(t, err)
+	assert.False(t, restored.Deleted)
+	assert.Equal(t, parent.Content, restored.Content)
+
+	_, err = s.RestoreComment(ctx, parent.ID)
+	assert.ErrorIs(t, err, storage.ErrConflict)
+	_, err = s.RestoreComment(ctx, reply.ID+1000)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	// Родителя, удалённого отдельно его автором, восстановление ответа не возвращает
+	reader := newUser(t, s, "reader")
+	own := newComment(t, s, post.ID, author.ID, nil, at(3))
+	answer := newComment(t, s, post.ID, reader.ID, &own.ID, at(4))
+	_, err = s.DeleteComment(ctx, answer.ID)
+	require.NoError(t, err)
+	_, err = s.DeleteComment(ctx, own.ID)
+	require.NoError(t, err)
+
+	_, err = s.RestoreComment(ctx, answer.ID)
+	assert.ErrorIs(t, err, storage.ErrConflict)
+	for _, id := range []uint{own.ID, answer.ID} {
+		_, err = s.GetComment(ctx, id)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
+	}
+}
+
+func testDeletePost(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	post := newPost(t, s, author.ID, at(0))
+	other := newPost(t, s, author.ID, at(1))
+	early := newComment(t, s, post.ID, author.ID, nil, at(2))
+	kept := newComment(t, s, post.ID, author.ID, nil, at(3))
+
+	_, err := s.DeleteComment(ctx, early.ID)
+	require.NoError(t, err)
+
+	require.NoError(t, s.DeletePost(ctx, post.ID))
+	_, err = s.GetPost(ctx, post.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	_, err = s.GetComment(ctx, kept.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+	assert.ErrorIs(t, s.DeletePost(ctx, post.ID), storage.ErrNotFound)
+
+	posts, err := s.GetPosts(ctx, storage.SortNewest)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{other.ID}, ids(posts))
+
+	deleted, err := s.GetDeletedPosts(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []uint{post.ID}, ids(deleted))
+
+	unscoped, err := s.GetPostUnscoped(ctx, post.ID)
+	require.NoError(t, err)
+	assert.True(t, unscoped.DeletedAt.Valid)
+
+	restored, err := s.RestorePost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.False(t, restored.DeletedAt.Valid)
+	_, err = s.GetComment(ctx, kept.ID)
+	assert.NoError(t, err, "комментарии, удалённые вместе с постом, возвращаются")
+	_, err = s.GetComment(ctx, early.ID)
+	assert.ErrorIs(t, err, storage.ErrNotFound, "удалённые раньше поста остаются удалёнными")
+
+	_, err = s.RestorePost(ctx, post.ID)
+	assert.ErrorIs(t, err, storage.ErrConflict)
+	_, err = s.RestorePost(ctx, post.ID+1000)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testRevisions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	post := newPost(t, s, author.ID, at(0))
+	untouched := newPost(t, s, author.ID, at(1))
+
+	for _, title := range []string{"второй", "третий"} {
+		current, err := s.GetPost(ctx, post.ID)
+		require.NoError(t, err)
+		edited := *current
+		edited.Title = title
+		require.NoError(t, s.UpdatePost(ctx, &edited))
+	}
+	current, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	same := *current
+	require.NoError(t, s.UpdatePost(ctx, &same))
+
+	revisions, err := s.GetRevisionsByEntityIDs(ctx, models.RevisionPost, []uint{post.ID, untouched.ID})
+	require.NoError(t, err)
+	require.Len(t, revisions[post.ID], 2, "ревизия пишется только при изменении текста")
+	assert.Equal(t, "второй", revisions[post.ID][0].Title, "ревизии идут от новых к старым")
+	assert.Equal(t, post.Title, revisions[post.ID][1].Title)
+	assert.NotNil(t, revisions[untouched.ID])
+	assert.Empty(t, revisions[untouched.ID])
+
+	comments, err := s.GetRevisionsByEntityIDs(ctx, models.RevisionComment, []uint{post.ID})
+	require.NoError(t, err)
+	assert.Empty(t, comments[post.ID], "ревизии постов и комментариев не смешиваются")
+
+	got, err := s.GetRevision(ctx, revisions[post.ID][1].ID)
+	require.NoError(t, err)
+	assert.Equal(t, post.ID, got.EntityID)
+	assert.Equal(t, models.RevisionPost, got.EntityType)
+
+	_, err = s.GetRevision(ctx, got.ID+1000)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testConcurrency(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	post := newPost(t, s, author.ID, at(0))
+
+	const workers, perWorker, removeEvery = 8, 10, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				comment := &models.Comment{PostID: post.ID, AuthorID: author.ID, Content: fmt.Sprintf("%d-%d", w, i)}
+				if err := s.CreateComment(ctx, comment); err != nil {
+					errs <- err
+					continue
+				}
+				if _, err := s.GetComments(ctx, post.ID, nil, nil, storage.SortNewest); err != nil {
+					errs <- err
+				}
+				// Удаления вперемешку с созданием не должны сбивать статистику поста
+				if i%removeEvery == 0 {
+					if _, err := s.DeleteComment(ctx, comment.ID); err != nil {
+						errs <- err
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	want := workers * (perWorker - perWorker/removeEvery)
+	comments, err := s.GetCommentsAfter(ctx, post.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, comments, want)
+	seen := make(map[uint]bool)
+	for _, comment := range comments {
+		assert.False(t, seen[comment.ID], "id %d выдан дважды", comment.ID)
+		seen[comment.ID] = true
+	}
+
+	got, err := s.GetPost(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, want, got.CommentCount)
+}
+
+func testTransactions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	author := newUser(t, s, "author")
+	post := newPost(t, s, author.ID, at(0))
+
+	errStop := errors.New("stop")
+	err := s.WithTx(ctx, func(tx storage.Storage) error {
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+
+	var comment *models.Comment
+	err = s.WithTx(ctx, func(tx storage.Storage) error {
+		comment = &models.Comment{PostID: post.ID, AuthorID: author.ID, Content: "в транзакции"}
+		if err := tx.CreateComment(ctx, comment); err != nil {
+			return err
+		}
+		return tx.WithTx(ctx, func(inner storage.Storage) error {
+			_, err := inner.GetComment(ctx, comment.ID)
+			return err
+		})
+	})
+	require.NoError(t, err)
+	_, err = s.GetComment(ctx, comment.ID)
+	require.NoError(t, err)
+
+	// Чтение и запись в одной транзакции не теряют конкурентные обновления
+	counter := newPost(t, s, author.ID, at(1))
+	counter.Content = "0"
+	require.NoError(t, s.UpdatePost(ctx, counter))
+
+	const workers, perWorker = 4, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*perWorker)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				errs <- s.WithTx(ctx, func(tx storage.Storage) error {
+					current, err := tx.GetPost(ctx, counter.ID)
+					if err != nil {
+						return err
+					}
+					n, err := strconv.Atoi(current.Content)
+					if err != nil {
+						return err
+					}
+					current.Content = strconv.Itoa(n + 1)
+					return tx.UpdatePost(ctx, current)
+				})
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	got, err := s.GetPost(ctx, counter.ID)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(workers*perWorker), got.Content)
+
+	// Ответ и удаление того же комментария блокируют пост, затем комментарий,
+	// поэтому не ждут друг друга: одна транзакция проходит, вторая видит её итог
+	const rounds = 10
+	for i := 0; i < rounds; i++ {
+		target := newComment(t, s, post.ID, author.ID, nil, at(10+i))
+		replyErr, deleteErr := make(chan error, 1), make(chan error, 1)
+		go func() {
+			replyErr <- s.WithTx(ctx, func(tx storage.Storage) error {
+				if _, err := tx.GetPost(ctx, post.ID); err != nil {
+					return err
+				}
+				if _, err := tx.GetComment(ctx, target.ID); err != nil {
+					return err
+				}
+				return tx.CreateComment(ctx, &models.Comment{PostID: post.ID, AuthorID: author.ID, ParentID: &target.ID, Content: "Ответ"})
+			})
+		}()
+		go func() {
+			deleteErr <- s.WithTx(ctx, func(tx storage.Storage) error {
+				if _, err := tx.GetPost(ctx, post.ID); err != nil {
+					return err
+				}
+				if _, err := tx.GetComment(ctx, target.ID); err != nil {
+					return err
+				}
+				_, err := tx.DeleteComment(ctx, target.ID)
+				return err
+			})
+		}()
+
+		require.NoError(t, <-deleteErr)
+		if err := <-replyErr; err != nil {
+			require.ErrorIs(t, err, storage.ErrNotFound, "ответ на уже удалённый комментарий")
+			continue
+		}
+		got, err := s.GetComment(ctx, target.ID)
+		require.NoError(t, err, "комментарий с ответом становится надгробием")
+		assert.True(t, got.Deleted)
+	}
+}
+
+func newUser(t *testing.T, s storage.Storage, username string) *models.User {
+	t.Helper()
+	ctx := context.Background()
+	user := &models.User{Username: username}
+	require.NoError(t, s.CreateUser(ctx, user))
+	return user
+}
+
+func newPost(t *testing.T, s storage.Storage, authorID uint, createdAt time.Time) *models.Post {
+	t.Helper()
+	ctx := context.Background()
+	post := &models.Post{Title: "Пост", Content: "Текст", AuthorID: authorID, CreatedAt: createdAt}
+	require.NoError(t, s.CreatePost(ctx, post))
+	return post
+}
+
+func newComment(t *testing.T, s storage.Storage, postID, authorID uint, parentID *uint, createdAt time.Time) *models.Comment {
+	t.Helper()
+	ctx := context.Background()
+	comment := &models.Comment{PostID: postID, AuthorID: authorID, ParentID: parentID, Content: "Комментарий", CreatedAt: createdAt}
+	require.NoError(t, s.CreateComment(ctx, comment))
+	return comment
+}
+
+func ids[T interface{ *models.Post | *models.Comment }](items []T) []uint {
+	result := make([]uint, len(items))
+	for i, item := range items {
+		switch v := any(item).(type) {
+		case *models.Post:
+			result[i] = v.ID
+		case *models.Comment:
+			result[i] = v.ID
+		}
+	}
+	return result
+}
@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Anabol1ks/ozon_tz/internal/models"
+)
+
+// ThreadComment — комментарий ветки вместе с его положением в дереве.
+type ThreadComment struct {
+	Comment *models.Comment
+	// Depth равна 0 у корневых комментариев
+	Depth int
+	// Path — id предков от корня, последним идёт сам комментарий
+	Path []uint
+}
+
+// flattenThread обходит дерево в глубину: каждый комментарий идёт сразу
+// после родителя, ответы одного уровня — от старых к новым.
+// rootLimit и maxDepth, равные 0, ничего не ограничивают.
+func flattenThread(roots []*models.Comment, rootLimit, maxDepth int, childrenOf func(parentID uint) []*models.Comment) []*ThreadComment {
+	sortOldest(roots)
+	if rootLimit > 0 && len(roots) > rootLimit {
+		roots = roots[:rootLimit]
+	}
+
+	thread := []*ThreadComment{}
+	var walk func(comments []*models.Comment, depth int, parentPath []uint)
+	walk = func(comments []*models.Comment, depth int, parentPath []uint) {
+		for _, comment := range comments {
+			path := append(append(make([]uint, 0, depth+1), parentPath...), comment.ID)
+			thread = append(thread, &ThreadComment{Comment: comment, Depth: depth, Path: path})
+			if maxDepth > 0 && depth+1 >= maxDepth {
+				continue
+			}
+			children := childrenOf(comment.ID)
+			sortOldest(children)
+			walk(children, depth+1, path)
+		}
+	}
+	walk(roots, 0, nil)
+	return thread
+}
+
+func sortOldest(comments []*models.Comment) {
+	sort.Slice(comments, func(i, j int) bool {
+		if comments[i].CreatedAt.Equal(comments[j].CreatedAt) {
+			return comments[i].ID < comments[j].ID
+		}
+		return comments[i].CreatedAt.Before(comments[j].CreatedAt)
+	})
+}
+
+// placeUnder заполняет путь и глубину ответа по родителю.
+func placeUnder(comment, parent *models.Comment) {
+	comment.Path = descendantPrefix(parent)
+	comment.Depth = parent.Depth + 1
+}
+
+// descendantPrefix — начало пути у всех потомков комментария.
+func descendantPrefix(comment *models.Comment) string {
+	return comment.Path + strconv.FormatUint(uint64(comment.ID), 10) + "/"
+}
+
+// AncestorIDs разбирает Path комментария в id предков от корня к родителю.
+func AncestorIDs(path string) []uint {
+	parts := strings.Split(strings.TrimSuffix(path, "/"), "/")
+	ids := make([]uint, 0, len(parts))
+	for _, part := range parts {
+		if id, err := strconv.ParseUint(part, 10, 64); err == nil {
+			ids = append(ids, uint(id))
+		}
+	}
+	return ids
+}